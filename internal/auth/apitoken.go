@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"go-commerce/internal/domain"
+)
+
+// Lifetimes of bearer tokens. Access tokens cannot be revoked, so they are
+// kept short; clients trade a refresh token for a new pair when they expire.
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// accessTokenIssuer is the iss claim of access tokens
+const accessTokenIssuer = "go-commerce"
+
+// TokenPair is what a client receives when it logs in or refreshes
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"` // Seconds until the access token expires
+	RefreshToken string `json:"refreshToken"`
+}
+
+// APITokens issues bearer tokens for clients that do not use cookies, such
+// as the mobile app. Access tokens are HMAC-signed JWTs checked without a
+// database lookup. Refresh tokens are random strings stored hashed; each can
+// be used once, and using one again revokes every token descended from the
+// same login, since it means the token was stolen.
+type APITokens struct {
+	refresh domain.RefreshTokenRepository
+	secret  []byte
+}
+
+// NewAPITokens creates the bearer token issuer using the server's persistent
+// access token secret
+func NewAPITokens(ctx context.Context, secrets domain.SecretRepository, refresh domain.RefreshTokenRepository) (*APITokens, error) {
+	secret, err := LoadOrCreateSecret(ctx, secrets, "access_tokens")
+	if err != nil {
+		return nil, err
+	}
+	return &APITokens{refresh: refresh, secret: secret}, nil
+}
+
+// hashRefreshToken hashes a refresh token for storage. The tokens are 256
+// random bits, so a fast hash is enough.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns a random refresh token
+func newRefreshToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// accessToken signs a new access token for a user
+func (t *APITokens) accessToken(userID int, now time.Time) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Issuer:    accessTokenIssuer,
+		Subject:   strconv.Itoa(userID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.secret)
+}
+
+// pair builds the response for a new access token and refresh token
+func (t *APITokens) pair(userID int, refreshToken string, now time.Time) (*TokenPair, error) {
+	access, err := t.accessToken(userID, now)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Issue starts a new token family for a user who has just authenticated
+func (t *APITokens) Issue(ctx context.Context, userID int) (*TokenPair, error) {
+	now := time.Now()
+
+	family := make([]byte, 16)
+	if _, err := rand.Read(family); err != nil {
+		return nil, err
+	}
+
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.refresh.Create(ctx, userID, hex.EncodeToString(family), hashRefreshToken(refresh), now, now.Add(RefreshTokenTTL)); err != nil {
+		return nil, err
+	}
+
+	return t.pair(userID, refresh, now)
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token.
+// The old refresh token stops working.
+func (t *APITokens) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	now := time.Now()
+
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	userID, err := t.refresh.Rotate(ctx, hashRefreshToken(refreshToken), hashRefreshToken(refresh), now, now.Add(RefreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return t.pair(userID, refresh, now)
+}
+
+// Revoke revokes the family of a refresh token, logging out the client that
+// holds it. Unknown tokens are ignored.
+func (t *APITokens) Revoke(ctx context.Context, refreshToken string) error {
+	return t.refresh.RevokeFamily(ctx, hashRefreshToken(refreshToken), time.Now())
+}
+
+// RevokeAllForUser revokes every refresh token of a user
+func (t *APITokens) RevokeAllForUser(ctx context.Context, userID int) error {
+	return t.refresh.RevokeAllForUser(ctx, userID, time.Now())
+}
+
+// Verify checks an access token and returns the user it was issued to
+func (t *APITokens) Verify(accessToken string) (int, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(accessToken, &claims, func(*jwt.Token) (interface{}, error) {
+		return t.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(accessTokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, domain.ErrInvalidToken
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, domain.ErrInvalidToken
+	}
+	return userID, nil
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go-commerce/internal/domain"
+)
+
+// CredentialPolicy holds the rules usernames and passwords must follow
+type CredentialPolicy struct {
+	UsernameMinLength int
+	UsernameMaxLength int
+	ReservedUsernames map[string]bool // Normalized usernames nobody may register
+	PasswordMinLength int
+	PasswordMaxLength int // In bytes; bcrypt cannot hash more than BcryptMaxPasswordLength
+	RequireLetter     bool
+	RequireUpper      bool
+	RequireLower      bool
+	RequireDigit      bool
+	RequireSymbol     bool            // Punctuation or another symbol
+	Blocklist         map[string]bool // Lowercased passwords that are never accepted
+}
+
+// DefaultCredentialPolicy returns the policy used unless configured otherwise
+func DefaultCredentialPolicy() *CredentialPolicy {
+	return &CredentialPolicy{
+		UsernameMinLength: 3,
+		UsernameMaxLength: 32,
+		ReservedUsernames: map[string]bool{},
+		PasswordMinLength: 10,
+		PasswordMaxLength: BcryptMaxPasswordLength,
+		RequireLetter:     true,
+		RequireDigit:      false,
+		Blocklist:         map[string]bool{},
+	}
+}
+
+// Validate checks that the policy's limits can be met
+func (p *CredentialPolicy) Validate() error {
+	var errs []error
+	if p.UsernameMinLength < 1 || p.UsernameMaxLength < p.UsernameMinLength {
+		errs = append(errs, errors.New("username lengths must be at least 1, the maximum no less than the minimum"))
+	}
+	if p.PasswordMinLength < 1 || p.PasswordMaxLength < p.PasswordMinLength {
+		errs = append(errs, errors.New("password lengths must be at least 1, the maximum no less than the minimum"))
+	}
+	return errors.Join(errs...)
+}
+
+// Reserve keeps usernames from being registered, e.g. "admin"
+func (p *CredentialPolicy) Reserve(usernames ...string) {
+	for _, name := range usernames {
+		p.ReservedUsernames[domain.NormalizeUsername(name)] = true
+	}
+}
+
+// IsReserved reports whether a normalized username may not be registered
+func (p *CredentialPolicy) IsReserved(username string) bool {
+	return p.ReservedUsernames[username]
+}
+
+// LoadBlocklist adds the passwords listed in a file, one per line, to the
+// policy's blocklist. Blank lines and lines starting with # are skipped.
+func (p *CredentialPolicy) LoadBlocklist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.Blocklist[strings.ToLower(line)] = true
+	}
+
+	return scanner.Err()
+}
+
+// ValidateUsername checks a normalized username against the policy. Usernames
+// may contain letters, digits, dots, hyphens and underscores. Letters must
+// all come from one script, so a name cannot pass for another by swapping in
+// lookalikes such as Cyrillic "а" for Latin "a".
+func (p *CredentialPolicy) ValidateUsername(username string) error {
+	n := utf8.RuneCountInString(username)
+	if n < p.UsernameMinLength || n > p.UsernameMaxLength {
+		return domain.Invalid("username", "username_length", fmt.Sprintf("username must be %d to %d characters", p.UsernameMinLength, p.UsernameMaxLength))
+	}
+	nameScript := ""
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '-' && r != '_' {
+			return domain.Invalid("username", "username_characters", "username may only contain letters, digits, dots, hyphens and underscores")
+		}
+		s := script(r)
+		if s == "" {
+			continue
+		}
+		if nameScript != "" && s != nameScript {
+			return domain.Invalid("username", "username_mixed_scripts", "username may not mix letters from different alphabets")
+		}
+		nameScript = s
+	}
+	if p.IsReserved(username) {
+		return domain.Invalid("username", "username_reserved", "this username is reserved, please choose another")
+	}
+	return nil
+}
+
+// script returns the Unicode script r belongs to, or "" for characters
+// shared by all scripts such as ASCII digits and punctuation
+func script(r rune) string {
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// ValidatePassword checks a password against the policy. The username is
+// needed to reject passwords that merely repeat it.
+func (p *CredentialPolicy) ValidatePassword(username, password string) error {
+	if utf8.RuneCountInString(password) < p.PasswordMinLength {
+		return domain.Invalid("password", "password_too_short", fmt.Sprintf("password must be at least %d characters", p.PasswordMinLength))
+	}
+	if len(password) > p.PasswordMaxLength {
+		return domain.Invalid("password", "password_too_long", fmt.Sprintf("password must be at most %d bytes", p.PasswordMaxLength))
+	}
+	if strings.TrimSpace(password) == "" {
+		return domain.Invalid("password", "password_blank", "password must not be blank")
+	}
+
+	var hasLetter, hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		hasLetter = hasLetter || unicode.IsLetter(r)
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+		hasDigit = hasDigit || unicode.IsDigit(r)
+		hasSymbol = hasSymbol || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}
+	if p.RequireLetter && !hasLetter {
+		return domain.Invalid("password", "password_needs_letter", "password must contain a letter")
+	}
+	if p.RequireUpper && !hasUpper {
+		return domain.Invalid("password", "password_needs_upper", "password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		return domain.Invalid("password", "password_needs_lower", "password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		return domain.Invalid("password", "password_needs_digit", "password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return domain.Invalid("password", "password_needs_symbol", "password must contain a punctuation mark or symbol")
+	}
+
+	lower := strings.ToLower(password)
+	if lower == domain.NormalizeUsername(username) {
+		return domain.Invalid("password", "password_is_username", "password must not be the same as the username")
+	}
+	if p.Blocklist[lower] {
+		return domain.Invalid("password", "password_too_common", "password is too common, please choose another")
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-commerce/internal/domain"
+	"go-commerce/internal/mail"
+)
+
+// Lifetimes of the tokens sent by email
+const (
+	EmailVerificationTTL = 48 * time.Hour
+	PasswordResetTTL     = time.Hour
+)
+
+// Limits on password reset requests, so the endpoint cannot be used to flood
+// an inbox or to probe many addresses from one client
+const (
+	PasswordResetsPerAddress = 3
+	PasswordResetsPerIP      = 10
+	PasswordResetWindow      = time.Hour
+)
+
+var ErrNoEmail = domain.BadRequest("no_email", "no email address on this account")
+
+// AccountEmails sends the verification and password reset emails and
+// redeems the tokens they contain. Links point at the configured public
+// address, never at the Host a request was sent to, so a forged Host header
+// cannot send a token to another site.
+type AccountEmails struct {
+	users    domain.UserRepository
+	profiles domain.ProfileRepository
+	mailer   mail.Mailer
+	tokens   *TokenSigner
+	policy   *CredentialPolicy
+	baseURL  string
+
+	resetsPerAddress *RateLimit
+	resetsPerIP      *RateLimit
+}
+
+// NewAccountEmails creates the account email flows. baseURL is the public
+// address of the store; throttle holds the password reset rate limits.
+func NewAccountEmails(users domain.UserRepository, profiles domain.ProfileRepository, mailer mail.Mailer, tokens *TokenSigner, policy *CredentialPolicy, throttle domain.LoginThrottleRepository, baseURL string) *AccountEmails {
+	return &AccountEmails{users: users, profiles: profiles, mailer: mailer, tokens: tokens, policy: policy,
+		baseURL:          strings.TrimSuffix(baseURL, "/"),
+		resetsPerAddress: NewRateLimit(throttle, PasswordResetsPerAddress, PasswordResetWindow),
+		resetsPerIP:      NewRateLimit(throttle, PasswordResetsPerIP, PasswordResetWindow),
+	}
+}
+
+// SendVerification emails a verification link to the user's current address
+func (a *AccountEmails) SendVerification(ctx context.Context, userID int) error {
+	profile, err := a.profiles.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if profile == nil || profile.Email == "" {
+		return ErrNoEmail
+	}
+
+	token, err := a.tokens.Issue(ctx, userID, TokenPurposeVerifyEmail, profile.Email, EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := a.baseURL + "/api/email/verify?token=" + url.QueryEscape(token)
+	return a.mailer.Send(ctx, mail.Message{
+		To:      profile.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Please confirm your email address by opening this link:\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not create an account, you can ignore this email.",
+			link, int(EmailVerificationTTL.Hours())),
+	})
+}
+
+// VerifyEmail redeems a verification token. The address is only marked
+// verified if it is still the one on the account.
+func (a *AccountEmails) VerifyEmail(ctx context.Context, token string) error {
+	return a.tokens.Redeem(ctx, token, TokenPurposeVerifyEmail, func(users domain.UserRepository, userID int, email string) error {
+		ok, err := users.MarkEmailVerified(ctx, userID, email)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return domain.ErrInvalidToken // The address changed since the email was sent
+		}
+		return nil
+	})
+}
+
+// RequestPasswordReset emails a reset link if an account uses the address,
+// subject to rate limits per address and per client IP. It returns how long
+// the client must wait when over a limit. The account is looked up and the
+// email sent after it returns, so neither the response nor its timing shows
+// whether the address belongs to an account; failures are only logged.
+func (a *AccountEmails) RequestPasswordReset(ctx context.Context, email, ip string) (time.Duration, error) {
+	email, err := domain.NormalizeEmail(email)
+	if err != nil {
+		return 0, err
+	}
+
+	wait, err := a.resetsPerIP.Allow(ctx, "reset-ip:"+ip)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	wait, err = a.resetsPerAddress.Allow(ctx, "reset-email:"+email)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if err := a.sendPasswordReset(ctx, email); err != nil {
+			slog.ErrorContext(ctx, "sending password reset email", "error", err)
+		}
+	}()
+	return 0, nil
+}
+
+// sendPasswordReset emails a reset link to the account using email, if any
+func (a *AccountEmails) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := a.users.GetByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := a.tokens.Issue(ctx, user.ID, TokenPurposePasswordReset, email, PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := a.baseURL + "/?resetToken=" + url.QueryEscape(token)
+	return a.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for the account %q.\n\n"+
+			"To choose a new password, open this link:\n\n%s\n\n"+
+			"The link expires in %d minutes and can be used once. If you did not ask for this, you can ignore this email.",
+			user.Username, link, int(PasswordResetTTL.Minutes())),
+	})
+}
+
+// ResetPassword redeems a reset token and sets a new password that satisfies
+// the credential policy. It returns the user whose password was reset.
+func (a *AccountEmails) ResetPassword(ctx context.Context, token, password string) (int, error) {
+	resetUserID := 0
+	err := a.tokens.Redeem(ctx, token, TokenPurposePasswordReset, func(users domain.UserRepository, userID int, email string) error {
+		user, err := users.GetByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return domain.ErrInvalidToken
+		}
+		if err := a.policy.ValidatePassword(user.Username, password); err != nil {
+			return err
+		}
+
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+
+		ok, err := users.ResetPassword(ctx, userID, email, hash)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return domain.ErrInvalidToken // The address changed since the email was sent
+		}
+		resetUserID = userID
+		return nil
+	})
+	return resetUserID, err
+}
@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/oauth2"
+
+	"go-commerce/internal/domain"
+)
+
+// OIDCLoginTimeout is how long a user has to complete a login at the provider
+const OIDCLoginTimeout = 10 * time.Minute
+
+// oidcClient makes the requests to login providers, each in a span of the
+// trace of the login it is for
+var oidcClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+var (
+	ErrUnknownProvider    = domain.NotFound("unknown_provider", "unknown login provider")
+	ErrInvalidOIDCState   = domain.BadRequest("invalid_login_state", "login request expired or was not started here")
+	ErrInvalidProviderCfg = errors.New("login provider needs a name, issuer and client ID")
+)
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// OIDCProviderConfig describes an OpenID Connect provider users can log in with
+type OIDCProviderConfig struct {
+	Name            string   `json:"name"` // Used in URLs, e.g. /api/oidc/<name>/login
+	DisplayName     string   `json:"displayName"`
+	Issuer          string   `json:"issuer"`
+	ClientID        string   `json:"clientId"`
+	ClientSecret    string   `json:"clientSecret"`
+	ClientSecretEnv string   `json:"clientSecretEnv"` // Read the secret from this environment variable instead
+	Scopes          []string `json:"scopes"`          // Requested in addition to "openid"
+}
+
+// LoadOIDCProviders reads provider configurations from a JSON array
+func LoadOIDCProviders(path string) ([]OIDCProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []OIDCProviderConfig
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, p := range providers {
+		if p.ClientSecretEnv != "" {
+			providers[i].ClientSecret = os.Getenv(p.ClientSecretEnv)
+		}
+	}
+	return providers, nil
+}
+
+// OIDCClaims are the ID token claims used to find or create an account
+type OIDCClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// OIDCRequest is the state kept in the session between sending the user to
+// the provider and their return
+type OIDCRequest struct {
+	Provider     string
+	State        string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    int64
+}
+
+// oidcProvider is a configured provider, discovered on first use
+type oidcProvider struct {
+	cfg OIDCProviderConfig
+
+	mu         sync.Mutex
+	discovered bool
+	endpoint   oauth2.Endpoint
+	verifier   *oidc.IDTokenVerifier
+}
+
+// OIDCLogin runs the OpenID Connect authorization code flow with PKCE
+// against the configured providers
+type OIDCLogin struct {
+	providers map[string]*oidcProvider
+	order     []string
+	policy    *CredentialPolicy
+}
+
+// NewOIDCLogin creates the OpenID Connect login flow. Providers are contacted
+// only when first used, so the server starts even if one is unreachable.
+// Usernames for new accounts are fitted to policy.
+func NewOIDCLogin(configs []OIDCProviderConfig, policy *CredentialPolicy) (*OIDCLogin, error) {
+	o := &OIDCLogin{providers: map[string]*oidcProvider{}, policy: policy}
+	for _, cfg := range configs {
+		if !providerNamePattern.MatchString(cfg.Name) || cfg.Issuer == "" || cfg.ClientID == "" {
+			return nil, ErrInvalidProviderCfg
+		}
+		if _, ok := o.providers[cfg.Name]; ok {
+			return nil, fmt.Errorf("login provider %q is configured twice", cfg.Name)
+		}
+		if cfg.DisplayName == "" {
+			cfg.DisplayName = cfg.Name
+		}
+		o.providers[cfg.Name] = &oidcProvider{cfg: cfg}
+		o.order = append(o.order, cfg.Name)
+	}
+	return o, nil
+}
+
+// ProviderInfo is how a provider is listed to clients
+type ProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// Providers lists the configured providers in configuration order
+func (o *OIDCLogin) Providers() []ProviderInfo {
+	infos := []ProviderInfo{}
+	for _, name := range o.order {
+		infos = append(infos, ProviderInfo{Name: name, DisplayName: o.providers[name].cfg.DisplayName})
+	}
+	return infos
+}
+
+// provider returns a provider, running discovery the first time it is used
+// and again after a failure
+func (o *OIDCLogin) provider(name string) (*oidcProvider, error) {
+	p, ok := o.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.discovered {
+		// The provider keeps the context to fetch signing keys later, so it
+		// must not be a request's
+		discovered, err := oidc.NewProvider(oidc.ClientContext(context.Background(), oidcClient), p.cfg.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("discovering login provider %q: %w", name, err)
+		}
+		p.endpoint = discovered.Endpoint()
+		p.verifier = discovered.Verifier(&oidc.Config{ClientID: p.cfg.ClientID})
+		p.discovered = true
+	}
+	return p, nil
+}
+
+// oauth2Config returns the OAuth 2.0 client configuration of a provider
+func (p *oidcProvider) oauth2Config(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     p.endpoint,
+		RedirectURL:  redirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID}, p.cfg.Scopes...),
+	}
+}
+
+// randomString returns n random bytes, base64url-encoded
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Begin starts a login with a provider. It returns the URL to send the user
+// to and the request to keep in their session until they come back.
+func (o *OIDCLogin) Begin(providerName, redirectURL string) (string, *OIDCRequest, error) {
+	p, err := o.provider(providerName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	state, err := randomString(24)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := randomString(24)
+	if err != nil {
+		return "", nil, err
+	}
+	req := &OIDCRequest{
+		Provider:     providerName,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		CreatedAt:    time.Now().Unix(),
+	}
+
+	authURL := p.oauth2Config(redirectURL).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(req.CodeVerifier))
+	return authURL, req, nil
+}
+
+// Finish exchanges the authorization code the provider sent back for an ID
+// token and returns its verified claims
+func (o *OIDCLogin) Finish(ctx context.Context, req *OIDCRequest, providerName, state, code, redirectURL string) (*OIDCClaims, error) {
+	if req == nil || req.Provider != providerName || req.State == "" || req.State != state ||
+		time.Since(time.Unix(req.CreatedAt, 0)) > OIDCLoginTimeout {
+		return nil, ErrInvalidOIDCState
+	}
+
+	p, err := o.provider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = oidc.ClientContext(ctx, oidcClient)
+	token, err := p.oauth2Config(redirectURL).Exchange(ctx, code, oauth2.VerifierOption(req.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("login provider returned no ID token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verifying ID token: %w", err)
+	}
+	if idToken.Nonce != req.Nonce {
+		return nil, errors.New("ID token nonce does not match the login request")
+	}
+
+	var claims OIDCClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	claims.Subject = idToken.Subject
+	return &claims, nil
+}
+
+// ResolveIdentity finds the user an external login belongs to, linking or
+// creating an account when needed:
+//
+//   - a login linked before returns its user;
+//   - otherwise, if loggedInUserID is not 0, the login is linked to that user;
+//   - otherwise, if the provider verified an email address that a local
+//     account has also verified, the login is linked to that account;
+//   - otherwise a new account without a password is created.
+func (o *OIDCLogin) ResolveIdentity(ctx context.Context, users domain.UserRepository, identities domain.IdentityRepository, provider string, claims *OIDCClaims, loggedInUserID int) (*domain.User, bool, error) {
+	userID, err := identities.FindUser(ctx, provider, claims.Subject)
+	if err != nil {
+		return nil, false, err
+	}
+	if userID != 0 {
+		if loggedInUserID != 0 && loggedInUserID != userID {
+			return nil, false, domain.ErrIdentityInUse
+		}
+		user, err := users.GetByID(ctx, userID)
+		return user, false, err
+	}
+
+	if loggedInUserID != 0 {
+		if err := identities.Link(ctx, loggedInUserID, provider, claims.Subject, claims.Email); err != nil {
+			return nil, false, err
+		}
+		user, err := users.GetByID(ctx, loggedInUserID)
+		return user, false, err
+	}
+
+	email, emailErr := domain.NormalizeEmail(claims.Email)
+	if claims.EmailVerified && emailErr == nil {
+		match, err := users.GetByVerifiedEmail(ctx, email)
+		if err != nil {
+			return nil, false, err
+		}
+		if match != nil {
+			if err := identities.Link(ctx, match.ID, provider, claims.Subject, claims.Email); err != nil {
+				return nil, false, err
+			}
+			return match, false, nil
+		}
+	}
+
+	user, err := identities.CreateUser(ctx, externalUser(o.policy, provider, claims))
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// externalUser describes the account to create for a new external login. The
+// username is taken from the claims and fitted to the credential policy;
+// the repository makes it unique.
+func externalUser(policy *CredentialPolicy, provider string, claims *OIDCClaims) domain.ExternalUser {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, domain.NormalizeUsername(base))
+	if len(base) > policy.UsernameMaxLength-4 {
+		base = base[:policy.UsernameMaxLength-4]
+	}
+	if len(base) < policy.UsernameMinLength || policy.IsReserved(base) {
+		base = "user"
+	}
+
+	user := domain.ExternalUser{
+		Username:      base,
+		DisplayName:   strings.TrimSpace(claims.Name),
+		Provider:      provider,
+		Subject:       claims.Subject,
+		IdentityEmail: claims.Email,
+	}
+	if email, err := domain.NormalizeEmail(claims.Email); err == nil && claims.EmailVerified {
+		user.VerifiedEmail = email
+	}
+	return user
+}
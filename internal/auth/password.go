@@ -0,0 +1,183 @@
+// Package auth implements how users prove who they are: passwords, login
+// throttling, email tokens, bearer tokens, API keys, two-factor codes and
+// OpenID Connect logins. It stores everything through the domain
+// repositories.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// BcryptMaxPasswordLength is the longest password, in bytes, bcrypt hashes
+const BcryptMaxPasswordLength = 72
+
+var (
+	ErrUnknownHashAlgorithm = errors.New("password hash algorithm must be bcrypt or argon2id")
+	ErrMalformedHash        = errors.New("malformed password hash")
+)
+
+// PasswordHashConfig selects the algorithm and parameters for new password
+// hashes. Stored hashes record their own algorithm and parameters, so they
+// keep verifying after the configuration changes.
+type PasswordHashConfig struct {
+	Algorithm         string
+	BcryptCost        int
+	Argon2Memory      uint32 // In KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	Argon2SaltLength  int
+	Argon2KeyLength   uint32
+}
+
+// DefaultPasswordHashConfig returns the hashing used unless configured otherwise
+func DefaultPasswordHashConfig() PasswordHashConfig {
+	return PasswordHashConfig{
+		Algorithm:         HashBcrypt,
+		BcryptCost:        12,
+		Argon2Memory:      64 * 1024,
+		Argon2Iterations:  3,
+		Argon2Parallelism: 2,
+		Argon2SaltLength:  16,
+		Argon2KeyLength:   32,
+	}
+}
+
+// Validate checks that the configuration can produce hashes
+func (c PasswordHashConfig) Validate() error {
+	switch c.Algorithm {
+	case HashBcrypt:
+		if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case HashArgon2id:
+		if c.Argon2Memory < 8*uint32(c.Argon2Parallelism) || c.Argon2Iterations < 1 || c.Argon2Parallelism < 1 {
+			return errors.New("argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread")
+		}
+		if c.Argon2SaltLength < 8 || c.Argon2KeyLength < 16 {
+			return errors.New("argon2id salt must be at least 8 bytes and key at least 16 bytes")
+		}
+	default:
+		return ErrUnknownHashAlgorithm
+	}
+	return nil
+}
+
+// passwordHashing is the configuration used by HashPassword
+var passwordHashing = DefaultPasswordHashConfig()
+
+// ConfigurePasswordHashing sets the algorithm and parameters for new hashes
+func ConfigurePasswordHashing(cfg PasswordHashConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	passwordHashing = cfg
+	return nil
+}
+
+// HashPassword hashes a password with the configured algorithm
+func HashPassword(password string) (string, error) {
+	return hashPasswordWith(passwordHashing, password)
+}
+
+// CheckPasswordHash checks if a password matches a hash
+func CheckPasswordHash(password, hash string) bool {
+	ok, _ := verifyPassword(passwordHashing, password, hash)
+	return ok
+}
+
+// hashPasswordWith hashes a password with the given configuration. Argon2id
+// hashes use the PHC string format: $argon2id$v=19$m=...,t=...,p=...$salt$key
+func hashPasswordWith(cfg PasswordHashConfig, password string) (string, error) {
+	switch cfg.Algorithm {
+	case HashBcrypt:
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), cfg.BcryptCost)
+		return string(bytes), err
+	case HashArgon2id:
+		salt := make([]byte, cfg.Argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, cfg.Argon2Iterations, cfg.Argon2Memory, cfg.Argon2Parallelism, cfg.Argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", ErrUnknownHashAlgorithm
+	}
+}
+
+// argon2idHash is a decoded argon2id PHC string
+type argon2idHash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// parseArgon2id decodes a hash produced by hashPasswordWith
+func parseArgon2id(hash string) (*argon2idHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrMalformedHash
+	}
+
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.parallelism); err != nil {
+		return nil, ErrMalformedHash
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, ErrMalformedHash
+	}
+
+	return &h, nil
+}
+
+// verifyPassword checks a password against a stored hash of either
+// algorithm. needsRehash reports whether the hash was made with a different
+// algorithm or parameters than cfg and should be replaced.
+func verifyPassword(cfg PasswordHashConfig, password, hash string) (ok bool, needsRehash bool) {
+	if strings.HasPrefix(hash, "$"+HashArgon2id+"$") {
+		h, err := parseArgon2id(hash)
+		if err != nil {
+			return false, false
+		}
+		key := argon2.IDKey([]byte(password), h.salt, h.iterations, h.memory, h.parallelism, uint32(len(h.key)))
+		if subtle.ConstantTimeCompare(key, h.key) != 1 {
+			return false, false
+		}
+		return true, cfg.Algorithm != HashArgon2id ||
+			h.memory != cfg.Argon2Memory || h.iterations != cfg.Argon2Iterations || h.parallelism != cfg.Argon2Parallelism ||
+			len(h.salt) != cfg.Argon2SaltLength || uint32(len(h.key)) != cfg.Argon2KeyLength
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || cfg.Algorithm != HashBcrypt || cost != cfg.BcryptCost
+}
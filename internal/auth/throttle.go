@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// Reasons recorded in the login audit log
+const (
+	LoginFailureBadPassword = "bad_password"
+	LoginFailureUnknownUser = "unknown_user"
+	LoginFailureThrottled   = "throttled"
+	LoginFailureBadTOTP     = "bad_two_factor_code"
+)
+
+// LoginThrottleConfig holds the limits applied to failed logins
+type LoginThrottleConfig struct {
+	MaxAccountFailures int           // Failures on one username before it is locked
+	MaxIPFailures      int           // Failures from one IP address before it is locked
+	BaseDelay          time.Duration // Wait after the first failure; doubles with each further failure
+	MaxDelay           time.Duration
+	LockoutDuration    time.Duration
+	FailureWindow      time.Duration // Failures older than this are forgotten
+}
+
+// DefaultLoginThrottleConfig returns the limits used unless configured otherwise
+func DefaultLoginThrottleConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		MaxAccountFailures: 5,
+		MaxIPFailures:      20,
+		BaseDelay:          time.Second,
+		MaxDelay:           time.Minute,
+		LockoutDuration:    15 * time.Minute,
+		FailureWindow:      15 * time.Minute,
+	}
+}
+
+// LoginThrottle slows down and then locks out repeated failed logins, both
+// per username and per client IP address. Counters are stored so they
+// survive restarts.
+type LoginThrottle struct {
+	repo domain.LoginThrottleRepository
+	cfg  LoginThrottleConfig
+}
+
+// NewLoginThrottle creates a login throttle
+func NewLoginThrottle(repo domain.LoginThrottleRepository, cfg LoginThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{repo: repo, cfg: cfg}
+}
+
+// load reads the state of a throttle key, forgetting failures that have aged
+// out or whose lockout has ended
+func (t *LoginThrottle) load(ctx context.Context, key string, now time.Time) (domain.ThrottleState, error) {
+	s, err := t.repo.Get(ctx, key)
+	if err != nil || s == nil {
+		return domain.ThrottleState{}, err
+	}
+
+	if s.LockedUntil != nil && !now.Before(*s.LockedUntil) {
+		return domain.ThrottleState{}, nil
+	}
+	if s.LockedUntil == nil && now.Sub(s.LastFailureAt) > t.cfg.FailureWindow {
+		return domain.ThrottleState{}, nil
+	}
+
+	return *s, nil
+}
+
+// delay returns how long to wait after the given number of failures
+func (t *LoginThrottle) delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	d := time.Duration(float64(t.cfg.BaseDelay) * math.Pow(2, float64(failures-1)))
+	if d > t.cfg.MaxDelay || d <= 0 {
+		return t.cfg.MaxDelay
+	}
+	return d
+}
+
+// retryAfter returns how long a key must wait before its next attempt
+func (t *LoginThrottle) retryAfter(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+	s, err := t.load(ctx, key, now)
+	if err != nil {
+		return 0, err
+	}
+	if s.LockedUntil != nil {
+		return s.LockedUntil.Sub(now), nil
+	}
+	if wait := s.LastFailureAt.Add(t.delay(s.Failures)).Sub(now); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Check reports how long the client must wait before trying to log in as
+// username. Zero means the attempt may go ahead.
+func (t *LoginThrottle) Check(ctx context.Context, username, ip string) (time.Duration, error) {
+	now := time.Now()
+
+	accountWait, err := t.retryAfter(ctx, "user:"+domain.NormalizeUsername(username), now)
+	if err != nil {
+		return 0, err
+	}
+	ipWait, err := t.retryAfter(ctx, "ip:"+ip, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if ipWait > accountWait {
+		return ipWait, nil
+	}
+	return accountWait, nil
+}
+
+// recordKeyFailure counts a failure against a key, locking it once max is
+// reached. The count is increased by the database, so a burst of parallel
+// failures cannot get more than max tries in before the lockout.
+func (t *LoginThrottle) recordKeyFailure(ctx context.Context, key string, max int, now time.Time) error {
+	s, err := t.repo.AddFailure(ctx, key, now, now.Add(-t.cfg.FailureWindow))
+	if err != nil {
+		return err
+	}
+	if s.Failures < max {
+		return nil
+	}
+
+	return t.repo.Lock(ctx, key, now.Add(t.cfg.LockoutDuration))
+}
+
+// RecordFailure counts a failed login against the username and IP address and
+// writes it to the audit log. userID is 0 when the username is unknown.
+func (t *LoginThrottle) RecordFailure(ctx context.Context, username, ip, userAgent string, userID int, reason string) error {
+	now := time.Now()
+	username = domain.NormalizeUsername(username)
+
+	// Attempts rejected by the throttle are audited but not counted again
+	if reason != LoginFailureThrottled {
+		if err := t.recordKeyFailure(ctx, "user:"+username, t.cfg.MaxAccountFailures, now); err != nil {
+			return err
+		}
+		if err := t.recordKeyFailure(ctx, "ip:"+ip, t.cfg.MaxIPFailures, now); err != nil {
+			return err
+		}
+	}
+
+	return t.repo.RecordAttempt(ctx, domain.LoginAttempt{
+		Username:  username,
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		Reason:    reason,
+		CreatedAt: now,
+	})
+}
+
+// RecordSuccess clears the failures counted against a username. Failures from
+// the IP address are kept so one valid account cannot be used to reset them.
+func (t *LoginThrottle) RecordSuccess(ctx context.Context, username string) error {
+	return t.repo.Delete(ctx, "user:"+domain.NormalizeUsername(username))
+}
+
+// RateLimit allows a number of requests per key within a window, then
+// refuses further ones for the length of the window. Counts share the login
+// throttle table, so keys must carry a prefix of their own.
+type RateLimit struct {
+	repo   domain.LoginThrottleRepository
+	max    int
+	window time.Duration
+}
+
+// NewRateLimit creates a rate limit of max requests per window
+func NewRateLimit(repo domain.LoginThrottleRepository, max int, window time.Duration) *RateLimit {
+	return &RateLimit{repo: repo, max: max, window: window}
+}
+
+// Allow counts a request against key and reports how long the client must
+// wait before it may go ahead. Zero means it may go ahead now.
+func (l *RateLimit) Allow(ctx context.Context, key string) (time.Duration, error) {
+	now := time.Now()
+	s, err := l.repo.AddFailure(ctx, key, now, now.Add(-l.window))
+	if err != nil {
+		return 0, err
+	}
+	if s.LockedUntil != nil {
+		return s.LockedUntil.Sub(now), nil
+	}
+	if s.Failures <= l.max {
+		return 0, nil
+	}
+
+	if err := l.repo.Lock(ctx, key, now.Add(l.window)); err != nil {
+		return 0, err
+	}
+	return l.window, nil
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// Purposes of single-use tokens sent to users
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposePasswordReset = "password_reset"
+)
+
+// LoadOrCreateSecret returns the named server secret, generating and storing
+// a random one the first time it is needed so it survives restarts
+func LoadOrCreateSecret(ctx context.Context, secrets domain.SecretRepository, name string) ([]byte, error) {
+	candidate := make([]byte, 32)
+	if _, err := rand.Read(candidate); err != nil {
+		return nil, err
+	}
+	return secrets.LoadOrCreate(ctx, name, candidate)
+}
+
+// TokenSigner issues and redeems signed, single-use tokens. A token carries
+// its database ID, purpose and expiry, signed with HMAC-SHA256; the stored
+// token records which user it belongs to and whether it has been used.
+type TokenSigner struct {
+	tokens domain.UserTokenRepository
+	secret []byte
+}
+
+// NewTokenSigner creates a signer using the server's persistent token secret
+func NewTokenSigner(ctx context.Context, secrets domain.SecretRepository, tokens domain.UserTokenRepository) (*TokenSigner, error) {
+	secret, err := LoadOrCreateSecret(ctx, secrets, "user_tokens")
+	if err != nil {
+		return nil, err
+	}
+	return &TokenSigner{tokens: tokens, secret: secret}, nil
+}
+
+// sign returns the base64url HMAC of a token payload
+func (s *TokenSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue creates a token for a user. Earlier unused tokens of the same purpose
+// are revoked so only the latest email link works. The email the token was
+// sent to is recorded so verification confirms that exact address.
+func (s *TokenSigner) Issue(ctx context.Context, userID int, purpose, email string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	id, err := s.tokens.Create(ctx, userID, purpose, email, now, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	payload := fmt.Sprintf("%d.%s.%d", id, purpose, expiresAt.Unix())
+	return payload + "." + s.sign(payload), nil
+}
+
+// Redeem checks a token and, inside a transaction, passes the user and the
+// email address the token was issued for to use. The token is marked used
+// only if use succeeds, so a rejected request (say, a password that fails
+// the policy) can be retried with the same link.
+func (s *TokenSigner) Redeem(ctx context.Context, token, purpose string, use func(users domain.UserRepository, userID int, email string) error) error {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return domain.ErrInvalidToken
+	}
+	payload, signature := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return domain.ErrInvalidToken
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 || parts[1] != purpose {
+		return domain.ErrInvalidToken
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return domain.ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return domain.ErrInvalidToken
+	}
+
+	return s.tokens.Redeem(ctx, id, purpose, use)
+}
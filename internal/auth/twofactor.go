@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults every authenticator app
+// supports, so they are not configurable.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+	TOTPSkew   = 1 // Steps either side of now that are still accepted
+)
+
+// TOTPIssuer names the store in authenticator apps
+const TOTPIssuer = "Go-Commerce"
+
+// RecoveryCodeCount is how many recovery codes a user is given at a time
+const RecoveryCodeCount = 10
+
+// TwoFactorLoginTimeout is how long a user has to enter their code after
+// giving a correct password
+const TwoFactorLoginTimeout = 5 * time.Minute
+
+var (
+	ErrInvalidTwoFactorCode    = domain.Unauthorized("invalid_two_factor_code", "invalid two-factor code")
+	ErrTwoFactorNotPending     = domain.BadRequest("two_factor_not_pending", "two-factor enrollment has not been started")
+	ErrTwoFactorAlreadyEnabled = domain.Conflict("two_factor_enabled", "two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = domain.BadRequest("two_factor_not_enabled", "two-factor authentication is not enabled")
+	ErrTwoFactorMandatory      = domain.Forbidden("two_factor_required", "two-factor authentication is required for this account")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is what an authenticator app needs to add an account. URI is
+// the otpauth:// provisioning URI, which is also the payload to encode in a QR code.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// totpModulus keeps the last TOTPDigits decimal digits of a truncated HMAC
+var totpModulus = func() uint32 {
+	m := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		m *= 10
+	}
+	return m
+}()
+
+// totpCode computes the code for a secret at a time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, value%totpModulus)
+}
+
+// matchTOTP returns the time step a code is valid for, allowing for clock
+// skew, or -1 if it matches none
+func matchTOTP(secret string, code string, now time.Time) int64 {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return -1
+	}
+	current := now.Unix() / int64(TOTPPeriod.Seconds())
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step
+		}
+	}
+	return -1
+}
+
+// BeginTOTPEnrollment generates a new secret for the user and returns it with
+// its provisioning URI. Two-factor authentication is enabled only once
+// ConfirmTOTPEnrollment receives a code generated from the secret.
+func BeginTOTPEnrollment(ctx context.Context, repo domain.TwoFactorRepository, userID int, issuer string) (*TOTPEnrollment, error) {
+	state, err := loadTwoFactor(ctx, repo, userID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(key)
+
+	if err := repo.SetPendingSecret(ctx, userID, secret); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(state.Username)
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    "otpauth://totp/" + label + "?" + params.Encode(),
+	}, nil
+}
+
+// loadTwoFactor reads a user's two-factor setup. Unknown users give
+// sql.ErrNoRows.
+func loadTwoFactor(ctx context.Context, repo domain.TwoFactorRepository, userID int) (*domain.TwoFactorState, error) {
+	state, err := repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, sql.ErrNoRows
+	}
+	return state, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication if code was
+// generated from the pending secret, and returns a fresh set of recovery codes
+func ConfirmTOTPEnrollment(ctx context.Context, repo domain.TwoFactorRepository, userID int, code string) ([]string, error) {
+	state, err := loadTwoFactor(ctx, repo, userID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if state.PendingSecret == "" {
+		return nil, ErrTwoFactorNotPending
+	}
+
+	step := matchTOTP(state.PendingSecret, normalizeTOTPCode(code), time.Now())
+	if step < 0 {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Enable(ctx, userID, step, hashes, time.Now()); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// TwoFactorEnabled reports whether the user has confirmed TOTP enrollment
+func TwoFactorEnabled(ctx context.Context, repo domain.TwoFactorRepository, userID int) (bool, error) {
+	state, err := loadTwoFactor(ctx, repo, userID)
+	if err != nil {
+		return false, err
+	}
+	return state.Enabled, nil
+}
+
+// normalizeTOTPCode strips the spaces authenticator apps show inside codes
+func normalizeTOTPCode(code string) string {
+	return strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+}
+
+// VerifySecondFactor checks a TOTP code or, failing that, an unused recovery
+// code. Each TOTP code and each recovery code is accepted only once.
+func VerifySecondFactor(ctx context.Context, repo domain.TwoFactorRepository, userID int, code string) error {
+	state, err := repo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if state == nil || !state.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step := matchTOTP(state.Secret, normalizeTOTPCode(code), time.Now()); step >= 0 {
+		// Only a step later than the last one used is accepted, so a code
+		// seen by someone else cannot be replayed within its validity window
+		ok, err := repo.ClaimStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	ok, err := repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code), time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// hashRecoveryCode hashes a recovery code for storage. Codes are random with
+// 50 bits of entropy, so a fast hash is enough; formatting is ignored.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes creates a set of recovery codes, returning them in plain
+// text along with the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	alphabet := base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := alphabet.EncodeToString(raw)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes, invalidating the old ones
+func RegenerateRecoveryCodes(ctx context.Context, repo domain.TwoFactorRepository, userID int) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.ReplaceRecoveryCodes(ctx, userID, hashes, time.Now()); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes counts a user's unused recovery codes
+func RemainingRecoveryCodes(ctx context.Context, repo domain.TwoFactorRepository, userID int) (int, error) {
+	return repo.CountRecoveryCodes(ctx, userID)
+}
+
+// DisableTwoFactor turns off two-factor authentication and deletes the
+// user's secret and recovery codes. Users who require it cannot turn it off.
+func DisableTwoFactor(ctx context.Context, repo domain.TwoFactorRepository, user *domain.User) error {
+	if user.RequiresTwoFactor() {
+		return ErrTwoFactorMandatory
+	}
+	return repo.Disable(ctx, user.ID)
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-commerce/internal/domain"
+)
+
+var tracer = otel.Tracer("go-commerce/internal/auth")
+
+var (
+	ErrWrongPassword = domain.Forbidden("wrong_password", "current password is incorrect")
+	ErrNoPassword    = domain.BadRequest("no_password", "this account has no password; set one with a password reset email")
+	ErrSamePassword  = domain.Invalid("newPassword", "same_password", "new password must differ from the current one")
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// CreateUser creates a new customer with a password. The username is stored
+// normalized; ErrUsernameTaken is returned if it exists in any letter case.
+func CreateUser(ctx context.Context, users domain.UserRepository, username, password string) (*domain.User, error) {
+	// Check before hashing so taken usernames are rejected quickly
+	existing, err := users.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, domain.ErrUsernameTaken
+	}
+
+	span := startPasswordSpan(ctx, "password hash")
+	hashedPassword, err := HashPassword(password)
+	span.End()
+	if err != nil {
+		return nil, err
+	}
+
+	return users.Create(ctx, username, hashedPassword)
+}
+
+// Authenticate checks a username and password. It returns the user if the
+// username exists, and whether the password matched. Unknown usernames are
+// checked against a dummy hash so they take as long as wrong passwords and
+// cannot be told apart by timing.
+func Authenticate(ctx context.Context, users domain.UserRepository, username, password string) (*domain.User, bool, error) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("not the password of any account")
+	})
+
+	user, err := users.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, false, err
+	}
+	span := startPasswordSpan(ctx, "password verify")
+	if user == nil {
+		CheckPasswordHash(password, dummyHash)
+		span.End()
+		return nil, false, nil
+	}
+
+	ok, needsRehash := verifyPassword(passwordHashing, password, user.Password)
+	span.End()
+	if ok && needsRehash {
+		rehashPassword(ctx, users, user, password)
+	}
+	return user, ok, nil
+}
+
+// rehashPassword replaces a hash made with outdated settings now that the
+// plain password is known. Failures are only logged; the old hash still works.
+func rehashPassword(ctx context.Context, users domain.UserRepository, user *domain.User, password string) {
+	span := startPasswordSpan(ctx, "password hash")
+	hash, err := HashPassword(password)
+	span.End()
+	if err != nil {
+		slog.ErrorContext(ctx, "rehashing password", "user_id", user.ID, "error", err)
+		return
+	}
+	if err := users.ReplacePasswordHash(ctx, user.ID, user.Password, hash); err != nil {
+		slog.ErrorContext(ctx, "rehashing password", "user_id", user.ID, "error", err)
+		return
+	}
+	user.Password = hash
+}
+
+// ChangePassword replaces a user's password after checking the current one.
+// The new password must satisfy the credential policy.
+func ChangePassword(ctx context.Context, users domain.UserRepository, policy *CredentialPolicy, userID int, currentPassword, newPassword string) error {
+	user, err := users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return sql.ErrNoRows
+	}
+	if user.Password == "" {
+		return ErrNoPassword
+	}
+	span := startPasswordSpan(ctx, "password verify")
+	ok := CheckPasswordHash(currentPassword, user.Password)
+	span.End()
+	if !ok {
+		return ErrWrongPassword
+	}
+	if newPassword == currentPassword {
+		return ErrSamePassword
+	}
+	if err := policy.ValidatePassword(user.Username, newPassword); err != nil {
+		return err
+	}
+
+	span = startPasswordSpan(ctx, "password hash")
+	hash, err := HashPassword(newPassword)
+	span.End()
+	if err != nil {
+		return err
+	}
+	return users.SetPassword(ctx, userID, hash)
+}
+
+// startPasswordSpan starts a span timing a password hash or check, which
+// are deliberately slow
+func startPasswordSpan(ctx context.Context, name string) trace.Span {
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(attribute.String("password.algorithm", passwordHashing.Algorithm)))
+	return span
+}
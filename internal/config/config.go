@@ -0,0 +1,355 @@
+// Package config holds the server's settings. They start from built-in
+// defaults adjusted by the selected environment profile and are then
+// overridden, in order, by the JSON config files, environment variables and
+// command line flags. Settings are plain values; the packages that use them
+// are given their own options, built from these in main, and check the
+// finer points themselves.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Environment profiles
+const (
+	EnvDevelopment = "development"
+	EnvTest        = "test"
+	EnvProduction  = "production"
+)
+
+// Config is the effective configuration of the server
+type Config struct {
+	Env      string         `json:"env"`
+	Server   ServerConfig   `json:"server"`
+	Database DatabaseConfig `json:"database"`
+	Session  SessionConfig  `json:"session"`
+	Mail     MailConfig     `json:"mail"`
+	Auth     AuthConfig     `json:"auth"`
+	Log      LogConfig      `json:"log"`
+	Metrics  MetricsConfig  `json:"metrics"`
+	Tracing  TracingConfig  `json:"tracing"`
+}
+
+// ServerConfig says where the server listens and what it serves
+type ServerConfig struct {
+	Addr       string `json:"addr"`       // Listen address, e.g. ":3000"
+	PublicURL  string `json:"publicUrl"`  // The address customers reach the shop at
+	StaticDir  string `json:"staticDir"`  // Storefront files, served at /
+	UploadsDir string `json:"uploadsDir"` // Review photos; outside StaticDir so unmoderated ones are not public
+	// On SIGTERM the server reports not ready for ShutdownDelay, so load
+	// balancers stop sending requests, then gives requests in flight up to
+	// ShutdownTimeout to finish
+	ShutdownDelay   Duration `json:"shutdownDelay"`
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// Requests from TrustedProxies, IP addresses or CIDR ranges, take the
+	// client address from ProxyHeader, which the proxies must set or
+	// overwrite. Other requests use the connection's address
+	TrustedProxies []string `json:"trustedProxies"`
+	ProxyHeader    string   `json:"proxyHeader"`
+}
+
+// DatabaseConfig selects the database
+type DatabaseConfig struct {
+	Driver         string `json:"driver"`         // sqlite or postgres
+	URL            string `json:"url"`            // SQLite file or PostgreSQL connection URL
+	SeedSampleData bool   `json:"seedSampleData"` // Fill an empty catalog with sample products; development only
+}
+
+// SessionConfig holds the cookie session settings
+type SessionConfig struct {
+	CookieName  string   `json:"cookieName"`
+	IdleTimeout Duration `json:"idleTimeout"` // A session unused this long expires
+	MaxLifetime Duration `json:"maxLifetime"` // A session expires this long after login
+	GCInterval  Duration `json:"gcInterval"`  // How often expired session data is deleted
+}
+
+// MailConfig says where outgoing email goes: to an SMTP server when
+// SMTPAddr is set, to File when that is set and to standard output otherwise
+type MailConfig struct {
+	From         string `json:"from"`
+	SMTPAddr     string `json:"smtpAddr"`
+	SMTPUsername string `json:"smtpUsername"`
+	SMTPPassword string `json:"smtpPassword"`
+	File         string `json:"file"`
+}
+
+// AuthConfig holds the login settings
+type AuthConfig struct {
+	PasswordHash      PasswordHashConfig     `json:"passwordHash"`
+	Credentials       CredentialPolicyConfig `json:"credentials"`
+	PasswordBlocklist string                 `json:"passwordBlocklist"` // Common passwords, one per line; optional
+	OIDCProvidersFile string                 `json:"oidcProvidersFile"` // Optional
+	OIDCMock          bool                   `json:"oidcMock"`          // Serve a mock OpenID Connect provider
+}
+
+// PasswordHashConfig selects how new password hashes are made
+type PasswordHashConfig struct {
+	Algorithm         string `json:"algorithm"` // bcrypt or argon2id
+	BcryptCost        int    `json:"bcryptCost"`
+	Argon2MemoryKiB   uint32 `json:"argon2MemoryKiB"`
+	Argon2Iterations  uint32 `json:"argon2Iterations"`
+	Argon2Parallelism uint8  `json:"argon2Parallelism"`
+}
+
+// CredentialPolicyConfig holds the rules new usernames and passwords must
+// follow
+type CredentialPolicyConfig struct {
+	UsernameMinLength int      `json:"usernameMinLength"`
+	UsernameMaxLength int      `json:"usernameMaxLength"`
+	ReservedUsernames []string `json:"reservedUsernames"` // Names nobody may register
+	PasswordMinLength int      `json:"passwordMinLength"`
+	PasswordMaxLength int      `json:"passwordMaxLength"` // In bytes; at most 72 with bcrypt
+	RequireLetter     bool     `json:"requireLetter"`
+	RequireUpper      bool     `json:"requireUpper"`
+	RequireLower      bool     `json:"requireLower"`
+	RequireDigit      bool     `json:"requireDigit"`
+	RequireSymbol     bool     `json:"requireSymbol"`
+}
+
+// LogConfig selects what is logged and how
+type LogConfig struct {
+	Level  string `json:"level"`  // debug, info, warn or error
+	Format string `json:"format"` // json or text
+}
+
+// MetricsConfig controls the Prometheus metrics served at /metrics. Outside
+// development they are served only with a token.
+type MetricsConfig struct {
+	Enabled bool   `json:"enabled"`
+	Token   string `json:"token"` // Bearer token scrapes must send
+}
+
+// TracingConfig selects where OpenTelemetry spans are sent
+type TracingConfig struct {
+	Exporter    string  `json:"exporter"`    // none, stdout or otlp
+	Endpoint    string  `json:"endpoint"`    // OTLP/HTTP collector URL
+	SampleRatio float64 `json:"sampleRatio"` // Fraction of new traces recorded
+}
+
+// Duration is a time.Duration written like "12h" in config files
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the development configuration
+func Default() *Config {
+	return &Config{
+		Env: EnvDevelopment,
+		Server: ServerConfig{
+			Addr:            ":3000",
+			PublicURL:       "http://localhost:3000",
+			StaticDir:       "./public",
+			UploadsDir:      "./uploads",
+			ShutdownTimeout: Duration(15 * time.Second),
+			ProxyHeader:     "X-Forwarded-For",
+		},
+		Database: DatabaseConfig{
+			Driver: "sqlite",
+			URL:    "./database/store.db",
+		},
+		Session: SessionConfig{
+			CookieName:  "session_id",
+			IdleTimeout: Duration(24 * time.Hour),
+			MaxLifetime: Duration(7 * 24 * time.Hour),
+			GCInterval:  Duration(10 * time.Second),
+		},
+		Mail: MailConfig{
+			From: "Go-Commerce <no-reply@localhost>",
+		},
+		Auth: AuthConfig{
+			PasswordHash: PasswordHashConfig{
+				Algorithm:         "bcrypt",
+				BcryptCost:        12,
+				Argon2MemoryKiB:   64 * 1024,
+				Argon2Iterations:  3,
+				Argon2Parallelism: 2,
+			},
+			Credentials: CredentialPolicyConfig{
+				UsernameMinLength: 3,
+				UsernameMaxLength: 32,
+				ReservedUsernames: []string{"admin", "administrator", "root", "support", "staff"},
+				PasswordMinLength: 10,
+				PasswordMaxLength: 72,
+				RequireLetter:     true,
+			},
+			PasswordBlocklist: "./config/common-passwords.txt",
+			OIDCProvidersFile: "./config/oidc-providers.json",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// profiles adjust the defaults for each environment
+var profiles = map[string]func(c *Config){
+	// Development starts with a sample catalog to browse
+	EnvDevelopment: func(c *Config) {
+		c.Database.SeedSampleData = true
+	},
+	// Tests get their own database, cheap password hashes and no metrics
+	EnvTest: func(c *Config) {
+		c.Database.URL = "./database/test.db"
+		c.Auth.PasswordHash.BcryptCost = 4
+		c.Metrics.Enabled = false
+	},
+	// Production has no usable defaults for where it runs; Validate insists
+	// on an https:// public URL. Load balancers get time to notice shutdown.
+	EnvProduction: func(c *Config) {
+		c.Server.PublicURL = ""
+		c.Server.ShutdownDelay = Duration(5 * time.Second)
+	},
+}
+
+// ForEnv returns the defaults for an environment profile
+func ForEnv(env string) (*Config, error) {
+	profile, ok := profiles[env]
+	if !ok {
+		return nil, fmt.Errorf("unknown environment %q; use development, test or production", env)
+	}
+	c := Default()
+	c.Env = env
+	profile(c)
+	return c, nil
+}
+
+// within reports whether dir is parent or lies below it
+func within(dir, parent string) bool {
+	absDir, err1 := filepath.Abs(dir)
+	absParent, err2 := filepath.Abs(parent)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absParent, absDir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Validate reports every setting that is missing or out of range
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr must be set")
+	publicURL, urlErr := url.Parse(c.Server.PublicURL)
+	check(urlErr == nil && (publicURL.Scheme == "http" || publicURL.Scheme == "https") && publicURL.Host != "",
+		"server.publicUrl must be an http:// or https:// URL")
+	check(c.Server.StaticDir != "", "server.staticDir must be set")
+	check(c.Server.UploadsDir != "", "server.uploadsDir must be set")
+	check(!within(c.Server.UploadsDir, c.Server.StaticDir), "server.uploadsDir must not be inside server.staticDir")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdownDelay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	for _, proxy := range c.Server.TrustedProxies {
+		_, addrErr := netip.ParseAddr(proxy)
+		_, prefixErr := netip.ParsePrefix(proxy)
+		check(addrErr == nil || prefixErr == nil, "server.trustedProxies: %q is not an IP address or CIDR range", proxy)
+	}
+	check(len(c.Server.TrustedProxies) == 0 || c.Server.ProxyHeader != "",
+		"server.proxyHeader must be set when server.trustedProxies is")
+
+	check(c.Database.Driver != "", "database.driver must be set")
+	check(c.Database.URL != "", "database.url must be set")
+	check(!c.Database.SeedSampleData || c.Env != EnvProduction, "database.seedSampleData must not be set in production")
+
+	check(c.Session.CookieName != "", "session.cookieName must be set")
+	check(c.Session.IdleTimeout > 0 && c.Session.MaxLifetime >= c.Session.IdleTimeout,
+		"session.idleTimeout must be positive and no longer than session.maxLifetime")
+	check(c.Session.GCInterval > 0, "session.gcInterval must be positive")
+
+	check(c.Mail.From != "", "mail.from must be set")
+	check(c.Auth.PasswordHash.Algorithm == "bcrypt" || c.Auth.PasswordHash.Algorithm == "argon2id",
+		"auth.passwordHash.algorithm must be bcrypt or argon2id")
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level must be debug, info, warn or error")
+	}
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format must be json or text")
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		endpoint, err := url.Parse(c.Tracing.Endpoint)
+		check(err == nil && (endpoint.Scheme == "http" || endpoint.Scheme == "https") && endpoint.Host != "",
+			"tracing.endpoint must be an http:// or https:// URL")
+	default:
+		check(false, "tracing.exporter must be none, stdout or otlp")
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+	check(!c.Metrics.Enabled || c.Metrics.Token != "" || c.Env == EnvDevelopment,
+		"metrics.token must be set when metrics are enabled outside development")
+
+	if c.Env == EnvProduction {
+		check(urlErr == nil && publicURL.Scheme == "https", "server.publicUrl must be https:// in production")
+		check(!c.Auth.OIDCMock, "auth.oidcMock must be off in production")
+	}
+
+	return errors.Join(errs...)
+}
+
+// PublicURL is the public address without a trailing slash
+func (c *Config) PublicURL() string {
+	return strings.TrimSuffix(c.Server.PublicURL, "/")
+}
+
+// SecureCookies reports whether cookies must only be sent over HTTPS
+func (c *Config) SecureCookies() bool {
+	return strings.HasPrefix(c.Server.PublicURL, "https://")
+}
+
+// redacted replaces a secret that is set
+const redacted = "[redacted]"
+
+var dsnPassword = regexp.MustCompile(`(\bpassword=)(?:'[^']*'|\S*)`)
+
+// Redacted returns a copy safe to print, with passwords replaced
+func (c *Config) Redacted() *Config {
+	r := *c
+	if r.Mail.SMTPPassword != "" {
+		r.Mail.SMTPPassword = redacted
+	}
+	if r.Metrics.Token != "" {
+		r.Metrics.Token = redacted
+	}
+	if u, err := url.Parse(r.Database.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			r.Database.URL = u.Redacted()
+		}
+	}
+	// PostgreSQL also takes "host=... password=..." connection strings
+	r.Database.URL = dsnPassword.ReplaceAllString(r.Database.URL, "${1}"+redacted)
+	return &r
+}
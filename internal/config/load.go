@@ -0,0 +1,259 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFile is the config file read unless -config or CONFIG_FILE names
+// another. It is optional; a file named explicitly is not.
+const DefaultFile = "./config/config.json"
+
+// setting is one configuration value as it is named in flags and
+// environment variables
+type setting struct {
+	key   string      // Flag name, the JSON path of the value
+	env   string      // Environment variable
+	value interface{} // Pointer into the Config
+	usage string
+}
+
+// settings lists every value that can be set from the environment or flags
+func (c *Config) settings() []setting {
+	return []setting{
+		{"server.addr", "LISTEN_ADDR", &c.Server.Addr, "listen address"},
+		{"server.publicUrl", "PUBLIC_URL", &c.Server.PublicURL, "address customers reach the shop at"},
+		{"server.staticDir", "STATIC_DIR", &c.Server.StaticDir, "storefront files served at /"},
+		{"server.uploadsDir", "UPLOADS_DIR", &c.Server.UploadsDir, "review photos, kept outside staticDir"},
+		{"server.trustedProxies", "TRUSTED_PROXIES", &c.Server.TrustedProxies, "comma-separated load balancer addresses or CIDR ranges"},
+		{"server.proxyHeader", "PROXY_HEADER", &c.Server.ProxyHeader, "header trusted proxies put the client address in"},
+		{"server.shutdownDelay", "SHUTDOWN_DELAY", &c.Server.ShutdownDelay, "how long to report not ready before shutting down"},
+		{"server.shutdownTimeout", "SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout, "how long requests in flight get to finish at shutdown"},
+		{"database.driver", "DATABASE_DRIVER", &c.Database.Driver, "sqlite or postgres"},
+		{"database.url", "DATABASE_URL", &c.Database.URL, "SQLite file or PostgreSQL connection URL"},
+		{"database.seedSampleData", "DATABASE_SEED_SAMPLE_DATA", &c.Database.SeedSampleData, "fill an empty catalog with sample products"},
+		{"session.cookieName", "SESSION_COOKIE_NAME", &c.Session.CookieName, "session cookie name"},
+		{"session.idleTimeout", "SESSION_IDLE_TIMEOUT", &c.Session.IdleTimeout, "sessions unused this long expire"},
+		{"session.maxLifetime", "SESSION_MAX_LIFETIME", &c.Session.MaxLifetime, "sessions expire this long after login"},
+		{"session.gcInterval", "SESSION_GC_INTERVAL", &c.Session.GCInterval, "how often expired session data is deleted"},
+		{"mail.from", "MAIL_FROM", &c.Mail.From, "sender of outgoing email"},
+		{"mail.smtpAddr", "SMTP_ADDR", &c.Mail.SMTPAddr, "SMTP server, e.g. localhost:1025"},
+		{"mail.smtpUsername", "SMTP_USERNAME", &c.Mail.SMTPUsername, "SMTP user"},
+		{"mail.smtpPassword", "SMTP_PASSWORD", &c.Mail.SMTPPassword, "SMTP password"},
+		{"mail.file", "MAIL_FILE", &c.Mail.File, "append outgoing email to this file instead of printing it"},
+		{"auth.passwordHash.algorithm", "PASSWORD_HASH_ALGORITHM", &c.Auth.PasswordHash.Algorithm, "bcrypt or argon2id"},
+		{"auth.passwordHash.bcryptCost", "BCRYPT_COST", &c.Auth.PasswordHash.BcryptCost, "bcrypt cost"},
+		{"auth.passwordHash.argon2MemoryKiB", "ARGON2_MEMORY_KIB", &c.Auth.PasswordHash.Argon2MemoryKiB, "argon2id memory in KiB"},
+		{"auth.passwordHash.argon2Iterations", "ARGON2_ITERATIONS", &c.Auth.PasswordHash.Argon2Iterations, "argon2id iterations"},
+		{"auth.passwordHash.argon2Parallelism", "ARGON2_PARALLELISM", &c.Auth.PasswordHash.Argon2Parallelism, "argon2id threads"},
+		{"auth.credentials.usernameMinLength", "USERNAME_MIN_LENGTH", &c.Auth.Credentials.UsernameMinLength, "shortest username allowed"},
+		{"auth.credentials.usernameMaxLength", "USERNAME_MAX_LENGTH", &c.Auth.Credentials.UsernameMaxLength, "longest username allowed"},
+		{"auth.credentials.reservedUsernames", "RESERVED_USERNAMES", &c.Auth.Credentials.ReservedUsernames, "comma-separated usernames nobody may register"},
+		{"auth.credentials.passwordMinLength", "PASSWORD_MIN_LENGTH", &c.Auth.Credentials.PasswordMinLength, "shortest password allowed, in characters"},
+		{"auth.credentials.passwordMaxLength", "PASSWORD_MAX_LENGTH", &c.Auth.Credentials.PasswordMaxLength, "longest password allowed, in bytes"},
+		{"auth.credentials.requireLetter", "PASSWORD_REQUIRE_LETTER", &c.Auth.Credentials.RequireLetter, "passwords must contain a letter"},
+		{"auth.credentials.requireUpper", "PASSWORD_REQUIRE_UPPER", &c.Auth.Credentials.RequireUpper, "passwords must contain an uppercase letter"},
+		{"auth.credentials.requireLower", "PASSWORD_REQUIRE_LOWER", &c.Auth.Credentials.RequireLower, "passwords must contain a lowercase letter"},
+		{"auth.credentials.requireDigit", "PASSWORD_REQUIRE_DIGIT", &c.Auth.Credentials.RequireDigit, "passwords must contain a digit"},
+		{"auth.credentials.requireSymbol", "PASSWORD_REQUIRE_SYMBOL", &c.Auth.Credentials.RequireSymbol, "passwords must contain a punctuation mark or symbol"},
+		{"auth.passwordBlocklist", "PASSWORD_BLOCKLIST", &c.Auth.PasswordBlocklist, "file of common passwords to refuse"},
+		{"auth.oidcProvidersFile", "OIDC_PROVIDERS_FILE", &c.Auth.OIDCProvidersFile, "OpenID Connect providers, a JSON array"},
+		{"auth.oidcMock", "OIDC_MOCK", &c.Auth.OIDCMock, "serve a mock OpenID Connect provider"},
+		{"log.level", "LOG_LEVEL", &c.Log.Level, "least severe level logged: debug, info, warn or error"},
+		{"log.format", "LOG_FORMAT", &c.Log.Format, "json or text"},
+		{"metrics.enabled", "METRICS_ENABLED", &c.Metrics.Enabled, "serve Prometheus metrics at /metrics"},
+		{"metrics.token", "METRICS_TOKEN", &c.Metrics.Token, "bearer token required to read /metrics"},
+		{"tracing.exporter", "TRACING_EXPORTER", &c.Tracing.Exporter, "where spans go: none, stdout or otlp"},
+		{"tracing.endpoint", "TRACING_ENDPOINT", &c.Tracing.Endpoint, "OTLP/HTTP collector URL"},
+		{"tracing.sampleRatio", "TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio, "fraction of new traces recorded, 0 to 1"},
+	}
+}
+
+// set parses s into the value a setting points to
+func set(value interface{}, s string) error {
+	switch v := value.(type) {
+	case *string:
+		*v = s
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *uint32:
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return err
+		}
+		*v = uint32(n)
+	case *uint8:
+		n, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return err
+		}
+		*v = uint8(n)
+	case *float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*v = f
+	case *Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*v = Duration(d)
+	default:
+		return fmt.Errorf("unsupported setting type %T", value)
+	}
+	return nil
+}
+
+// flagValue records a flag's value so it can be applied after the files
+// and environment it overrides
+type flagValue struct {
+	raw   string
+	isSet bool
+	bool  bool
+}
+
+func (f *flagValue) String() string   { return f.raw }
+func (f *flagValue) IsBoolFlag() bool { return f.bool }
+
+func (f *flagValue) Set(s string) error {
+	f.raw, f.isSet = s, true
+	return nil
+}
+
+// Load builds the configuration for the command line args, which may be
+// followed by a command and its arguments, and validates it. The
+// environment profile comes from -env or APP_ENV and defaults to
+// development. The config file and, next to it, config.<env>.json for the
+// profile are read when they exist. It returns the arguments after the
+// flags.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("go-commerce", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-commerce [flags] [set-role <username> <role> | config print]")
+		fs.PrintDefaults()
+	}
+
+	env := &flagValue{}
+	file := &flagValue{}
+	fs.Var(env, "env", "environment profile: development, test or production (APP_ENV)")
+	fs.Var(file, "config", "config file (CONFIG_FILE, default "+DefaultFile+")")
+
+	// Flags are registered against a throwaway Config only for their names
+	// and types; their values are applied to the real one last
+	flags := map[string]*flagValue{}
+	for _, s := range Default().settings() {
+		v := &flagValue{}
+		_, v.bool = s.value.(*bool)
+		flags[s.key] = v
+		fs.Var(v, s.key, s.usage+" ("+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	envName := env.raw
+	if !env.isSet {
+		envName = os.Getenv("APP_ENV")
+	}
+	if envName == "" {
+		envName = EnvDevelopment
+	}
+	c, err := ForEnv(envName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path, required := file.raw, file.isSet
+	if !required {
+		path = os.Getenv("CONFIG_FILE")
+		required = path != ""
+	}
+	if path == "" {
+		path = DefaultFile
+	}
+	if err := c.loadFile(path, required); err != nil {
+		return nil, nil, err
+	}
+	profilePath := strings.TrimSuffix(path, filepath.Ext(path)) + "." + envName + filepath.Ext(path)
+	if err := c.loadFile(profilePath, false); err != nil {
+		return nil, nil, err
+	}
+
+	for _, s := range c.settings() {
+		if v := os.Getenv(s.env); v != "" {
+			if err := set(s.value, v); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range c.settings() {
+		if f := flags[s.key]; f.isSet {
+			if err := set(s.value, f.raw); err != nil {
+				return nil, nil, fmt.Errorf("-%s: %w", s.key, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return c, fs.Args(), nil
+}
+
+// loadFile reads a JSON config file over c. Settings the file leaves out
+// keep their values; unknown ones are an error so typos don't go unnoticed.
+func (c *Config) loadFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	env := c.Env
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	// The profile is chosen before the files are read
+	c.Env = env
+	return nil
+}
+
+// Print writes the configuration as JSON with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(c.Redacted())
+}
@@ -0,0 +1,51 @@
+package domain
+
+import "time"
+
+// PersonalDataExport is everything stored about a user, as returned by
+// AccountRepository.Export. Secrets (password hash, two-factor secret, key
+// and token hashes) are left out; the export says whether they exist.
+type PersonalDataExport struct {
+	ExportedAt       time.Time       `json:"exportedAt"`
+	Profile          *Profile        `json:"profile"`
+	Role             string          `json:"role"`
+	HasPassword      bool            `json:"hasPassword"`
+	TwoFactorEnabled bool            `json:"twoFactorEnabled"`
+	EmailAddresses   []ExportedEmail `json:"emailAddresses"`
+	Orders           []Order         `json:"orders"`
+	Reviews          []Review        `json:"reviews"`
+	ReviewVotes      []ExportedVote  `json:"reviewVotes"`
+	LinkedLogins     []Identity      `json:"linkedLogins"`
+	APIKeys          []APIKey        `json:"apiKeys"`
+	LoginAttempts    []ExportedLogin `json:"failedLogins"`
+	EmailTokens      []ExportedToken `json:"emailTokens"`
+}
+
+// ExportedEmail is an email address on file for the user. Accounts have
+// one; there are no postal addresses in the store.
+type ExportedEmail struct {
+	Address  string `json:"address"`
+	Verified bool   `json:"verified"`
+}
+
+// ExportedVote is a helpfulness vote the user cast on a review
+type ExportedVote struct {
+	ReviewID int  `json:"reviewId"`
+	Helpful  bool `json:"helpful"`
+}
+
+// ExportedLogin is a failed login recorded against the user
+type ExportedLogin struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExportedToken is a verification or password reset email sent to the user
+type ExportedToken struct {
+	Purpose   string     `json:"purpose"`
+	Email     string     `json:"email"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+}
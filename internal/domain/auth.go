@@ -0,0 +1,115 @@
+package domain
+
+import "time"
+
+// API key scopes. A request made with an API key may only use the endpoints
+// its scopes cover; admin scopes also need a staff or admin account.
+const (
+	ScopeReadCatalog  = "read:catalog"
+	ScopeWriteCart    = "write:cart"
+	ScopeReadOrders   = "read:orders"
+	ScopeWriteOrders  = "write:orders"
+	ScopeWriteReviews = "write:reviews"
+	ScopeReadProfile  = "read:profile"
+	ScopeAdminReviews = "admin:reviews"
+	ScopeAdminCatalog = "admin:catalog"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, forged,
+	// expired or already used. Callers should not tell these cases apart to
+	// clients.
+	ErrInvalidToken = Invalid("token", "invalid_token", "invalid or expired token")
+
+	// ErrInvalidRefreshToken is returned for refresh tokens that are
+	// unknown, expired, revoked or already used
+	ErrInvalidRefreshToken = Unauthorized("invalid_refresh_token", "invalid or expired refresh token")
+
+	ErrAPIKeyNotFound    = NotFound("api_key_not_found", "API key not found")
+	ErrSessionNotFound   = NotFound("session_not_found", "session not found")
+	ErrIdentityInUse     = Conflict("identity_in_use", "this login is already linked to another account")
+	ErrLastLoginMethod   = Conflict("last_login_method", "cannot unlink the only way to log in to this account")
+	ErrIdentityNotLinked = NotFound("identity_not_linked", "login provider is not linked to this account")
+)
+
+// APIKey is a personal API key as shown to its owner. The key itself is only
+// returned once, when it is created.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"userId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // The first characters of the key, to recognize it
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// HasScope reports whether the key was given a scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity is an external login linked to a user
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ExternalUser describes an account to create for a new external login
+type ExternalUser struct {
+	Username      string // Made unique by appending a number if taken
+	DisplayName   string
+	VerifiedEmail string // Kept on the account unless another account uses it
+	Provider      string
+	Subject       string
+	IdentityEmail string // The address the provider reported, verified or not
+}
+
+// UserSession is a logged-in browser session as shown to its owner
+type UserSession struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"-"`
+	IDHash     string    `json:"-"` // Hash of the session cookie value
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"` // The absolute limit; idle sessions expire sooner
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	Current    bool      `json:"current"` // Whether this is the session making the request
+}
+
+// ThrottleState is the failed login count kept for a username or IP address
+type ThrottleState struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// LoginAttempt is a failed login recorded in the audit log. UserID is 0
+// when the username is unknown.
+type LoginAttempt struct {
+	Username  string
+	UserID    int
+	IP        string
+	UserAgent string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// TwoFactorState is a user's two-factor authentication setup
+type TwoFactorState struct {
+	Username      string
+	Secret        string // Set once enrollment is confirmed
+	PendingSecret string // Set between starting and confirming enrollment
+	Enabled       bool
+}
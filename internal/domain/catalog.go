@@ -0,0 +1,108 @@
+// Package domain holds the store's entities, the rules they follow and the
+// repository interfaces the rest of the application stores them through.
+// It depends on nothing but the standard library.
+package domain
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxCatalogNameLength is the longest product or category name allowed, in characters
+const MaxCatalogNameLength = 200
+
+var (
+	ErrProductNotFound  = NotFound("product_not_found", "product not found")
+	ErrCategoryNotFound = NotFound("category_not_found", "category not found")
+	ErrInvalidName      = Invalid("name", "invalid_name", "name must be 1 to 200 characters without control characters")
+)
+
+// NormalizeCatalogName trims a product or category name and checks it
+func NormalizeCatalogName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxCatalogNameLength {
+		return "", ErrInvalidName
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", ErrInvalidName
+		}
+	}
+	return name, nil
+}
+
+// Product represents a product in the store
+type Product struct {
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Price       float64       `json:"price"`
+	ImageURL    string        `json:"imageUrl"`
+	CategoryID  int           `json:"categoryId"`
+	Slug        string        `json:"slug"`
+	Rating      ProductRating `json:"rating"`
+}
+
+// Sort orders accepted by ProductRepository.List
+const (
+	ProductSortDefault = ""
+	ProductSortRating  = "rating"
+)
+
+// ProductRating summarizes the approved reviews of a product
+type ProductRating struct {
+	Average   float64        `json:"average"`
+	Count     int            `json:"count"`
+	Histogram map[string]int `json:"histogram"` // Number of reviews per star, keyed "1" to "5"
+}
+
+// NewProductRating builds a rating summary from per-star review counts,
+// where histogram[0] holds the one-star reviews
+func NewProductRating(histogram [5]int) ProductRating {
+	rating := ProductRating{Histogram: make(map[string]int, len(histogram))}
+	total := 0
+	for i, n := range histogram {
+		stars := i + 1
+		rating.Histogram[strconv.Itoa(stars)] = n
+		rating.Count += n
+		total += stars * n
+	}
+	if rating.Count > 0 {
+		rating.Average = math.Round(float64(total)/float64(rating.Count)*100) / 100
+	}
+	return rating
+}
+
+// Category represents a product category
+type Category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// Entity types that have slugs
+const (
+	SlugEntityProduct  = "product"
+	SlugEntityCategory = "category"
+)
+
+// Slugify turns a name into a lowercase, hyphen-separated URL segment.
+// Letters and digits from any script are kept so non-Latin names still get
+// readable slugs.
+func Slugify(name string) string {
+	var b strings.Builder
+	lastHyphen := true
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			lastHyphen = false
+		} else if !lastHyphen {
+			b.WriteRune('-')
+			lastHyphen = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
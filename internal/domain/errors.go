@@ -0,0 +1,104 @@
+package domain
+
+import "strings"
+
+// ErrorKind says what went wrong with a request in terms the API answers
+// with an HTTP status
+type ErrorKind string
+
+// Error kinds
+const (
+	KindValidation   ErrorKind = "validation"   // The request is malformed or breaks a rule
+	KindUnauthorized ErrorKind = "unauthorized" // The caller is not logged in or gave wrong credentials
+	KindForbidden    ErrorKind = "forbidden"    // The caller may not do this
+	KindNotFound     ErrorKind = "not_found"    // The record does not exist
+	KindConflict     ErrorKind = "conflict"     // The request clashes with the stored data
+)
+
+// Error is a failure the client can act on. Its code is stable and meant for
+// programs; its message is for people and may change. Errors defined in
+// this and other packages are matched with errors.Is as before, by kind and
+// code.
+type Error struct {
+	Kind    ErrorKind
+	Code    string       // e.g. "username_taken"
+	Message string       // Shown to the user
+	Fields  []FieldError // The fields at fault, for validation errors
+}
+
+// FieldError names a request field that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is an Error of the same kind and code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Code == e.Code
+}
+
+// Invalid creates a validation error about one field
+func Invalid(field, code, message string) *Error {
+	return &Error{Kind: KindValidation, Code: code, Message: message,
+		Fields: []FieldError{{Field: field, Code: code, Message: message}}}
+}
+
+// Validation combines the validation errors about several fields into one.
+// Errors of other kinds are returned as they are, the first one winning.
+func Validation(errs ...error) error {
+	var fields []FieldError
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		e, ok := err.(*Error)
+		if !ok || e.Kind != KindValidation {
+			return err
+		}
+		fields = append(fields, e.Fields...)
+	}
+	switch len(fields) {
+	case 0:
+		return nil
+	case 1:
+		return Invalid(fields[0].Field, fields[0].Code, fields[0].Message)
+	}
+	messages := make([]string, len(fields))
+	for i, f := range fields {
+		messages[i] = f.Message
+	}
+	return &Error{Kind: KindValidation, Code: "invalid_fields", Message: strings.Join(messages, "; "), Fields: fields}
+}
+
+// BadRequest creates a validation error about the request as a whole, such
+// as a body that is not JSON
+func BadRequest(code, message string) *Error {
+	return &Error{Kind: KindValidation, Code: code, Message: message}
+}
+
+// Unauthorized creates an error for a caller that is not logged in or gave
+// wrong credentials
+func Unauthorized(code, message string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Message: message}
+}
+
+// Forbidden creates an error for something the caller may not do
+func Forbidden(code, message string) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Message: message}
+}
+
+// NotFound creates an error for a record that does not exist
+func NotFound(code, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message}
+}
+
+// Conflict creates an error for a request that clashes with stored data
+func Conflict(code, message string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Message: message}
+}
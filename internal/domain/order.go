@@ -0,0 +1,47 @@
+package domain
+
+import "time"
+
+// ErrEmptyCart is returned for an order from a cart with nothing in it
+var ErrEmptyCart = BadRequest("empty_cart", "cannot create an empty order")
+
+// Cart represents a shopping cart
+type Cart struct {
+	ID    int        `json:"id"`
+	Items []CartItem `json:"items"`
+}
+
+// CartItem represents an item in a shopping cart
+type CartItem struct {
+	ID        int     `json:"id"`
+	CartID    int     `json:"cartId"`
+	ProductID int     `json:"productId"`
+	Quantity  int     `json:"quantity"`
+	Product   Product `json:"product"`
+}
+
+// Order represents an order in the system
+type Order struct {
+	ID        int         `json:"id"`
+	UserID    int         `json:"userId"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Items     []OrderItem `json:"items"`
+}
+
+// OrderItem represents an item in an order
+type OrderItem struct {
+	ID        int     `json:"id"`
+	OrderID   int     `json:"orderId"`
+	ProductID int     `json:"productId"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"` // Price at the time of purchase
+}
+
+// Total is the sum of the order's item prices times their quantities
+func (o *Order) Total() float64 {
+	var total float64
+	for _, item := range o.Items {
+		total += item.Price * float64(item.Quantity)
+	}
+	return total
+}
@@ -0,0 +1,115 @@
+package domain
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Review privacy preferences. With ReviewPrivacyAnonymous a user's reviews
+// show neither their display name nor their avatar.
+const (
+	ReviewPrivacyPublic    = "public"
+	ReviewPrivacyAnonymous = "anonymous"
+)
+
+// Names shown on reviews when the author has none or wants to stay anonymous
+const (
+	DefaultAuthorName   = "Customer"
+	AnonymousAuthorName = "Anonymous"
+)
+
+// MaxDisplayNameLength is the longest display name allowed, in characters
+const MaxDisplayNameLength = 50
+
+var (
+	ErrInvalidDisplayName   = Invalid("displayName", "invalid_display_name", "display name must be 1 to 50 characters without control characters")
+	ErrInvalidAvatarURL     = Invalid("avatarUrl", "invalid_avatar_url", "avatar URL must be an http(s) URL or an uploaded image")
+	ErrInvalidReviewPrivacy = Invalid("reviewPrivacy", "invalid_review_privacy", "review privacy must be public or anonymous")
+)
+
+// Profile is the part of a user's account they can see and edit themselves
+type Profile struct {
+	ID            int    `json:"id"`
+	Username      string `json:"username"`
+	DisplayName   string `json:"displayName"`
+	AvatarURL     string `json:"avatarUrl"`
+	ReviewPrivacy string `json:"reviewPrivacy"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+}
+
+// ProfileUpdate holds the profile fields to change; nil fields are left as they are
+type ProfileUpdate struct {
+	DisplayName   *string `json:"displayName"`
+	AvatarURL     *string `json:"avatarUrl"`
+	ReviewPrivacy *string `json:"reviewPrivacy"`
+}
+
+// Normalize trims the fields of an update and checks them, returning the
+// update to store
+func (u ProfileUpdate) Normalize() (ProfileUpdate, error) {
+	if u.DisplayName != nil {
+		name := strings.TrimSpace(*u.DisplayName)
+		if err := validateDisplayName(name); err != nil {
+			return u, err
+		}
+		u.DisplayName = &name
+	}
+
+	if u.AvatarURL != nil {
+		avatarURL := strings.TrimSpace(*u.AvatarURL)
+		if err := validateAvatarURL(avatarURL); err != nil {
+			return u, err
+		}
+		u.AvatarURL = &avatarURL
+	}
+
+	if u.ReviewPrivacy != nil {
+		switch *u.ReviewPrivacy {
+		case ReviewPrivacyPublic, ReviewPrivacyAnonymous:
+		default:
+			return u, ErrInvalidReviewPrivacy
+		}
+	}
+
+	return u, nil
+}
+
+// PublicAuthorName returns the name and avatar to show on a review given the
+// author's profile settings
+func PublicAuthorName(displayName, avatarURL, privacy string) (string, string) {
+	if privacy == ReviewPrivacyAnonymous {
+		return AnonymousAuthorName, ""
+	}
+	if displayName == "" {
+		return DefaultAuthorName, avatarURL
+	}
+	return displayName, avatarURL
+}
+
+// validateDisplayName checks a trimmed display name
+func validateDisplayName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > MaxDisplayNameLength {
+		return ErrInvalidDisplayName
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return ErrInvalidDisplayName
+		}
+	}
+	return nil
+}
+
+// validateAvatarURL accepts absolute http(s) URLs and paths of uploaded images
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" || strings.HasPrefix(avatarURL, "/uploads/") {
+		return nil
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidAvatarURL
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// The repositories below are how the rest of the application reads and
+// stores data. Lookups of a single record return nil and no error when the
+// record does not exist, except where a method names the NotFound error it
+// returns instead. Methods that enforce a rule on the stored data, such as a
+// review's length or a unique email address, return the matching error from
+// this package.
+
+// ProductRepository stores products
+type ProductRepository interface {
+	// List returns the active products matching an optional search term and
+	// category ID. With sortBy set to ProductSortRating the best rated
+	// products come first.
+	List(ctx context.Context, search, categoryID, sortBy string) ([]Product, error)
+	// Get returns a product, or ErrProductNotFound
+	Get(ctx context.Context, id int) (*Product, error)
+	// Rename changes a product's name and regenerates its slug. The old slug
+	// keeps resolving to the product. It returns ErrProductNotFound for an
+	// unknown ID.
+	Rename(ctx context.Context, id int, name string) (*Product, error)
+}
+
+// CategoryRepository stores product categories
+type CategoryRepository interface {
+	List(ctx context.Context) ([]Category, error)
+	// Get returns a category, or ErrCategoryNotFound
+	Get(ctx context.Context, id int) (*Category, error)
+	// Rename changes a category's name and regenerates its slug. The old slug
+	// keeps resolving to the category. It returns ErrCategoryNotFound for an
+	// unknown ID.
+	Rename(ctx context.Context, id int, name string) (*Category, error)
+}
+
+// SlugRepository looks up products and categories by slug
+type SlugRepository interface {
+	// Resolve returns the ID of the entity with a slug and the entity's
+	// current slug, which differs from the given one when the slug is an old
+	// one. An ID of 0 means the slug is unknown or the entity is not shown
+	// in the store.
+	Resolve(ctx context.Context, entityType, slug string) (int, string, error)
+	// Slugs lists the current slugs of the entities of a type that are shown
+	// in the store, in ID order
+	Slugs(ctx context.Context, entityType string) ([]string, error)
+}
+
+// CartRepository stores shopping carts
+type CartRepository interface {
+	Create(ctx context.Context) (int, error)
+	Get(ctx context.Context, id int) (*Cart, error)
+	// AddItem adds a quantity of a product to a cart, on top of any already
+	// in it. It returns ErrProductNotFound for an unknown product.
+	AddItem(ctx context.Context, cartID, productID, quantity int) error
+}
+
+// OrderRepository stores orders
+type OrderRepository interface {
+	// CreateFromCart turns a cart's items into an order at their current
+	// prices and empties the cart. It returns ErrEmptyCart for an empty cart.
+	CreateFromCart(ctx context.Context, cartID, userID int) (*Order, error)
+	// ListByUser returns a user's orders with their items, newest first
+	ListByUser(ctx context.Context, userID int) ([]Order, error)
+}
+
+// ReviewRepository stores product reviews. Changes to a review keep the
+// rating summary of its product up to date.
+type ReviewRepository interface {
+	// ListByProduct returns a product's approved reviews in the given sort
+	// order, newest first by default
+	ListByProduct(ctx context.Context, productID int, sortBy string) ([]Review, error)
+	// ListByStatus returns the reviews in a moderation state, oldest first
+	ListByStatus(ctx context.Context, status string) ([]Review, error)
+	// ListByUser returns a user's reviews in any moderation state, newest first
+	ListByUser(ctx context.Context, userID int) ([]Review, error)
+	// Get returns a review, or ErrReviewNotFound
+	Get(ctx context.Context, id int) (*Review, error)
+	// GetByMediaURL returns the review a photo is attached to, or
+	// ErrReviewNotFound
+	GetByMediaURL(ctx context.Context, url string) (*Review, error)
+	// Submit stores a user's review of a product. Each user has at most one
+	// review per product, so submitting again edits the existing review. New
+	// and edited reviews go back to the moderation queue. The returned bool
+	// reports whether a new review was created.
+	Submit(ctx context.Context, productID, userID, rating int, comment string) (*Review, bool, error)
+	// Moderate records a staff decision on a review. It returns
+	// ErrReviewNotFound for an unknown review.
+	Moderate(ctx context.Context, id, moderatorID int, status, note string) (*Review, error)
+	// Vote records whether a user found an approved review helpful. Each user
+	// has one vote per review; voting again replaces the earlier vote.
+	// Reviews that are unknown or not approved give ErrReviewNotFound.
+	Vote(ctx context.Context, reviewID, userID int, helpful bool) (*Review, error)
+	// Reply sets the merchant's official reply to a review. An empty text
+	// removes the reply. It returns ErrReviewNotFound for an unknown review.
+	Reply(ctx context.Context, reviewID, staffID int, text string) (*Review, error)
+	// AddMedia attaches a stored photo to a review and returns the review to
+	// the moderation queue. It returns ErrReviewNotFound for an unknown review.
+	AddMedia(ctx context.Context, reviewID int, url, contentType string) (*Review, error)
+}
+
+// UserRepository stores user accounts
+type UserRepository interface {
+	// Create stores a new customer. ErrUsernameTaken is returned if the
+	// username exists in any letter case.
+	Create(ctx context.Context, username, passwordHash string) (*User, error)
+	// GetByID looks a user up by ID. Like the other lookups it returns nil
+	// for deleted accounts.
+	GetByID(ctx context.Context, id int) (*User, error)
+	// GetByUsername looks a user up by username, ignoring letter case
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	// GetByEmail looks a user up by email address, ignoring letter case
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	// GetByVerifiedEmail is GetByEmail for addresses the user has verified
+	GetByVerifiedEmail(ctx context.Context, email string) (*User, error)
+	// SetRole changes the role of the user with the given username
+	SetRole(ctx context.Context, username, role string) error
+	// SetEmail changes a user's email address. A new address starts out
+	// unverified.
+	SetEmail(ctx context.Context, userID int, email string) error
+	// MarkEmailVerified marks the address verified if it is still the user's.
+	// It reports whether it was.
+	MarkEmailVerified(ctx context.Context, userID int, email string) (bool, error)
+	SetPassword(ctx context.Context, userID int, passwordHash string) error
+	// ReplacePasswordHash stores a new hash of the same password, unless the
+	// password was changed meanwhile
+	ReplacePasswordHash(ctx context.Context, userID int, oldHash, newHash string) error
+	// ResetPassword sets a new password if email is still the user's address,
+	// which it then counts as verified. It reports whether it was.
+	ResetPassword(ctx context.Context, userID int, email, passwordHash string) (bool, error)
+}
+
+// ProfileRepository stores the parts of an account users edit themselves
+type ProfileRepository interface {
+	Get(ctx context.Context, userID int) (*Profile, error)
+	// Update validates and applies changes to a user's profile
+	Update(ctx context.Context, userID int, update ProfileUpdate) (*Profile, error)
+}
+
+// SecretRepository stores the server's signing secrets
+type SecretRepository interface {
+	// LoadOrCreate returns the named secret, storing candidate as the secret
+	// if there is none yet
+	LoadOrCreate(ctx context.Context, name string, candidate []byte) ([]byte, error)
+}
+
+// UserTokenRepository stores the single-use tokens sent to users by email
+type UserTokenRepository interface {
+	// Create stores a token and revokes the user's earlier unused tokens of
+	// the same purpose. It returns the new token's ID.
+	Create(ctx context.Context, userID int, purpose, email string, createdAt, expiresAt time.Time) (int64, error)
+	// Redeem marks a token used and, in the same transaction, passes its user
+	// and email address to use along with the users as seen by that
+	// transaction. The token stays unused if use fails. ErrInvalidToken is
+	// returned for tokens that are unknown or already used.
+	Redeem(ctx context.Context, id int64, purpose string, use func(users UserRepository, userID int, email string) error) error
+}
+
+// LoginThrottleRepository stores failed login counters and the login audit log
+type LoginThrottleRepository interface {
+	Get(ctx context.Context, key string) (*ThrottleState, error)
+	// AddFailure atomically counts a failure against a key, first forgetting
+	// failures from before forgetBefore and lockouts that have ended by now,
+	// and returns the resulting counters
+	AddFailure(ctx context.Context, key string, now, forgetBefore time.Time) (*ThrottleState, error)
+	// Lock locks a key until the given time
+	Lock(ctx context.Context, key string, until time.Time) error
+	Delete(ctx context.Context, key string) error
+	RecordAttempt(ctx context.Context, attempt LoginAttempt) error
+}
+
+// RefreshTokenRepository stores bearer refresh tokens by hash. Tokens
+// descended from the same login form a family.
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, userID int, family, tokenHash string, createdAt, expiresAt time.Time) error
+	// Rotate uses up a refresh token and stores its successor in the same
+	// family, returning the user. Presenting a used token again revokes its
+	// family. ErrInvalidRefreshToken is returned for tokens that cannot be used.
+	Rotate(ctx context.Context, oldHash, newHash string, now, expiresAt time.Time) (int, error)
+	// RevokeFamily revokes the family of a token. Unknown tokens are ignored.
+	RevokeFamily(ctx context.Context, tokenHash string, now time.Time) error
+	RevokeAllForUser(ctx context.Context, userID int, now time.Time) error
+}
+
+// APIKeyRepository stores personal API keys by hash
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *APIKey, keyHash string) (int, error)
+	// ListByUser returns a user's keys, newest first, including revoked ones
+	ListByUser(ctx context.Context, userID int) ([]APIKey, error)
+	GetByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	// Revoke revokes a key belonging to ownerID, or to anyone if ownerID is
+	// 0. ErrAPIKeyNotFound is returned if there is no such key.
+	Revoke(ctx context.Context, id, ownerID int, now time.Time) error
+	TouchLastUsed(ctx context.Context, id int, now time.Time) error
+}
+
+// TwoFactorRepository stores TOTP secrets and recovery codes
+type TwoFactorRepository interface {
+	Get(ctx context.Context, userID int) (*TwoFactorState, error)
+	SetPendingSecret(ctx context.Context, userID int, secret string) error
+	// Enable makes the pending secret the user's secret, with step as the
+	// last time step used, and replaces their recovery codes
+	Enable(ctx context.Context, userID int, step int64, codeHashes []string, now time.Time) error
+	// ClaimStep records a TOTP time step as used. It reports false if the
+	// step is not later than the last one used.
+	ClaimStep(ctx context.Context, userID int, step int64) (bool, error)
+	// UseRecoveryCode marks an unused recovery code used, reporting whether
+	// there was one
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string, now time.Time) error
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+	// Disable deletes the user's secrets and recovery codes
+	Disable(ctx context.Context, userID int) error
+}
+
+// IdentityRepository stores external logins linked to users
+type IdentityRepository interface {
+	ListByUser(ctx context.Context, userID int) ([]Identity, error)
+	// FindUser returns the user an external login is linked to, or 0
+	FindUser(ctx context.Context, provider, subject string) (int, error)
+	// Link links an external login to a user. Linking the same login again is
+	// a no-op; a login linked to someone else gives ErrIdentityInUse.
+	Link(ctx context.Context, userID int, provider, subject, email string) error
+	// Unlink removes an external login from a user, as long as they can still
+	// log in with a password or another linked login
+	Unlink(ctx context.Context, userID int, provider string) error
+	// CreateUser creates an account without a password for a new external
+	// login and links the login to it
+	CreateUser(ctx context.Context, user ExternalUser) (*User, error)
+}
+
+// SessionRepository records who each logged-in browser session belongs to
+type SessionRepository interface {
+	// Start records a new session, forgetting the record of the session it
+	// replaces, if any, and the user's sessions last seen before idleCutoff
+	Start(ctx context.Context, session *UserSession, previousHash string, idleCutoff time.Time) error
+	Get(ctx context.Context, idHash string, userID int) (*UserSession, error)
+	// Touch records that a session was used
+	Touch(ctx context.Context, id int, now time.Time, ip, userAgent string) error
+	DeleteByHash(ctx context.Context, idHash string) error
+	// ListActive returns a user's unexpired sessions, most recently used first
+	ListActive(ctx context.Context, userID int, now, idleCutoff time.Time) ([]UserSession, error)
+	// Delete ends one of a user's sessions. ErrSessionNotFound is returned if
+	// the user has no such session.
+	Delete(ctx context.Context, id, userID int) error
+	// DeleteAllForUser ends every session of a user except the one with
+	// exceptHash, which may be "" to end them all
+	DeleteAllForUser(ctx context.Context, userID int, exceptHash string) error
+}
+
+// AccountRepository works on everything stored about a user at once
+type AccountRepository interface {
+	// Export collects everything stored about a user
+	Export(ctx context.Context, userID int) (*PersonalDataExport, error)
+	// Delete erases a user's personal data. The user's row is kept, with the
+	// username replaced and everything identifying cleared, so the orders and
+	// reviews that point at it keep working; reviews then show as anonymous.
+	// Credentials, linked logins, keys, tokens and the login audit trail are
+	// deleted, which also ends token, API key and session access.
+	Delete(ctx context.Context, userID int) error
+}
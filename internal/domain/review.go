@@ -0,0 +1,193 @@
+package domain
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+)
+
+// Review moderation states
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+	ReviewStatusHidden   = "hidden"
+)
+
+// Sort orders accepted by ReviewRepository.ListByProduct
+const (
+	ReviewSortNewest  = "newest"
+	ReviewSortHelpful = "helpful"
+	ReviewSortRating  = "rating"
+)
+
+// MaxReviewCommentLength is the longest comment a review may carry, in characters
+const MaxReviewCommentLength = 2000
+
+// MaxReviewMedia is the number of photos a review may carry
+const MaxReviewMedia = 5
+
+var (
+	ErrInvalidRating       = Invalid("rating", "invalid_rating", "rating must be between 1 and 5")
+	ErrCommentTooLong      = Invalid("comment", "comment_too_long", "comment must be at most 2000 characters")
+	ErrInvalidReviewStatus = Invalid("status", "invalid_review_status", "status must be one of approved, rejected or hidden")
+	ErrInvalidReviewSort   = Invalid("sort", "invalid_sort", "sort must be one of newest, helpful or rating")
+	ErrOwnReviewVote       = Forbidden("own_review_vote", "you cannot vote on your own review")
+	ErrNotReviewAuthor     = Forbidden("not_review_author", "only the author of a review can add photos to it")
+	ErrTooManyReviewMedia  = Invalid("photo", "too_many_photos", "a review can have at most 5 photos")
+	ErrReviewNotFound      = NotFound("review_not_found", "review not found")
+)
+
+// Review represents a user review for a product as stored and shown to staff.
+// Customers are sent PublicReview.
+type Review struct {
+	ID               int           `json:"id"`
+	ProductID        int           `json:"productId"`
+	UserID           int           `json:"userId"`
+	Rating           int           `json:"rating"`
+	Comment          string        `json:"comment"`
+	CreatedAt        time.Time     `json:"createdAt"`
+	UpdatedAt        *time.Time    `json:"updatedAt,omitempty"`
+	VerifiedPurchase bool          `json:"verifiedPurchase"`
+	Status           string        `json:"status"`
+	ModerationNote   string        `json:"moderationNote,omitempty"`
+	HelpfulCount     int           `json:"helpfulCount"`
+	UnhelpfulCount   int           `json:"unhelpfulCount"`
+	Reply            *ReviewReply  `json:"reply,omitempty"`
+	Media            []ReviewMedia `json:"media"`
+	Author           ReviewAuthor  `json:"author"`
+}
+
+// PublicReview is a review as customers see it. Authors are only shown by
+// their public profile, and moderation notes are for staff.
+type PublicReview struct {
+	ID               int           `json:"id"`
+	ProductID        int           `json:"productId"`
+	Rating           int           `json:"rating"`
+	Comment          string        `json:"comment"`
+	CreatedAt        time.Time     `json:"createdAt"`
+	UpdatedAt        *time.Time    `json:"updatedAt,omitempty"`
+	VerifiedPurchase bool          `json:"verifiedPurchase"`
+	Status           string        `json:"status"`
+	HelpfulCount     int           `json:"helpfulCount"`
+	UnhelpfulCount   int           `json:"unhelpfulCount"`
+	Reply            *ReviewReply  `json:"reply,omitempty"`
+	Media            []ReviewMedia `json:"media"`
+	Author           ReviewAuthor  `json:"author"`
+}
+
+// Public returns the customers' view of the review
+func (r *Review) Public() *PublicReview {
+	return &PublicReview{
+		ID:               r.ID,
+		ProductID:        r.ProductID,
+		Rating:           r.Rating,
+		Comment:          r.Comment,
+		CreatedAt:        r.CreatedAt,
+		UpdatedAt:        r.UpdatedAt,
+		VerifiedPurchase: r.VerifiedPurchase,
+		Status:           r.Status,
+		HelpfulCount:     r.HelpfulCount,
+		UnhelpfulCount:   r.UnhelpfulCount,
+		Reply:            r.Reply,
+		Media:            r.Media,
+		Author:           r.Author,
+	}
+}
+
+// PublicReviews returns the customers' view of each review
+func PublicReviews(reviews []Review) []*PublicReview {
+	public := make([]*PublicReview, len(reviews))
+	for i := range reviews {
+		public[i] = reviews[i].Public()
+	}
+	return public
+}
+
+// ReviewAuthor is the public view of a review's author. It never carries the
+// username or anything else used to sign in.
+type ReviewAuthor struct {
+	DisplayName   string `json:"displayName"`
+	AvatarURL     string `json:"avatarUrl,omitempty"`
+	VerifiedBuyer bool   `json:"verifiedBuyer"` // The author has placed at least one order
+}
+
+// ReviewReply is the merchant's official answer to a review
+type ReviewReply struct {
+	Text      string    `json:"text"`
+	RepliedAt time.Time `json:"repliedAt"`
+}
+
+// ReviewMedia is a photo attached to a review
+type ReviewMedia struct {
+	ID          int    `json:"id"`
+	URL         string `json:"url"`
+	ContentType string `json:"contentType"`
+}
+
+// ValidateReview checks a review's rating and comment before it is stored
+func ValidateReview(rating int, comment string) error {
+	if rating < 1 || rating > 5 {
+		return ErrInvalidRating
+	}
+	if utf8.RuneCountInString(comment) > MaxReviewCommentLength {
+		return ErrCommentTooLong
+	}
+	return nil
+}
+
+// ValidateReviewStatus checks a moderation decision
+func ValidateReviewStatus(status string) error {
+	switch status {
+	case ReviewStatusApproved, ReviewStatusRejected, ReviewStatusHidden:
+		return nil
+	}
+	return ErrInvalidReviewStatus
+}
+
+// ValidateReviewReply checks a trimmed merchant reply
+func ValidateReviewReply(text string) error {
+	if utf8.RuneCountInString(text) > MaxReviewCommentLength {
+		return ErrCommentTooLong
+	}
+	return nil
+}
+
+// MediaStorage stores the photos attached to reviews and returns the URL
+// each is served from. The files are not public: the review handler serves
+// a photo only once its review is visible to the requester.
+type MediaStorage interface {
+	Save(folder string, data []byte) (url string, contentType string, err error)
+	Delete(url string) error
+	// Path returns the file an image's URL refers to
+	Path(url string) (string, error)
+}
+
+// AddReviewMedia attaches a photo to the user's own review. Photos are
+// moderated along with the text, so the review returns to the queue. The
+// stored file is removed again if the review cannot be updated.
+func AddReviewMedia(ctx context.Context, reviews ReviewRepository, media MediaStorage, reviewID, userID int, data []byte) (*Review, error) {
+	review, err := reviews.Get(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review.UserID != userID {
+		return nil, ErrNotReviewAuthor
+	}
+	if len(review.Media) >= MaxReviewMedia {
+		return nil, ErrTooManyReviewMedia
+	}
+
+	url, contentType, err := media.Save("reviews", data)
+	if err != nil {
+		return nil, err
+	}
+
+	review, err = reviews.AddMedia(ctx, reviewID, url, contentType)
+	if err != nil {
+		media.Delete(url)
+		return nil, err
+	}
+
+	return review, nil
+}
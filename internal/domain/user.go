@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"net/mail"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// User roles. Staff can moderate reviews; admins can do everything staff can.
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
+
+var (
+	ErrInvalidRole   = Invalid("role", "invalid_role", "role must be one of customer, staff or admin")
+	ErrUsernameTaken = Conflict("username_taken", "username is already taken")
+	ErrInvalidEmail  = Invalid("email", "invalid_email", "email address is not valid")
+	ErrEmailTaken    = Conflict("email_taken", "email address is already in use")
+	ErrUserNotFound  = NotFound("user_not_found", "user not found")
+)
+
+// User represents a user in the system
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"-"` // The password hash is not exposed in JSON
+	Role     string `json:"role"`
+}
+
+// IsStaff reports whether the user may perform staff actions such as review moderation
+func (u *User) IsStaff() bool {
+	return u.Role == RoleStaff || u.Role == RoleAdmin
+}
+
+// RequiresTwoFactor reports whether the user must use two-factor authentication
+// whether or not they chose to enroll
+func (u *User) RequiresTwoFactor() bool {
+	return u.Role == RoleAdmin
+}
+
+// ValidateRole checks that role is one of the user roles
+func ValidateRole(role string) error {
+	switch role {
+	case RoleCustomer, RoleStaff, RoleAdmin:
+		return nil
+	}
+	return ErrInvalidRole
+}
+
+// NormalizeUsername trims and lowercases a username so lookups are
+// case-insensitive. NFKC folds compatibility forms such as fullwidth letters
+// into the plain ones, so "ａｄｍｉｎ" is "admin".
+func NormalizeUsername(username string) string {
+	return strings.ToLower(norm.NFKC.String(strings.TrimSpace(username)))
+}
+
+// NormalizeEmail checks that email is a bare address and lowercases it
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(addr.Address), nil
+}
@@ -0,0 +1,167 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/domain"
+)
+
+// registerAccount adds the account self-service endpoints and the session
+// management endpoints, for seeing where the account is logged in and
+// logging other browsers out
+func (h *AuthHandler) registerAccount(router fiber.Router) {
+	router.Post("/password/change", h.changePassword)
+	router.Get("/account/export", h.exportAccount)
+	router.Delete("/account", h.deleteAccount)
+
+	router.Get("/sessions", h.listSessions)
+	// Ends every session but the one making the request
+	router.Delete("/sessions", h.revokeOtherSessions)
+	router.Delete("/sessions/:id", h.revokeSession)
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func (h *AuthHandler) changePassword(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.Users)
+	if err != nil {
+		return err
+	}
+
+	var req changePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	// Guessing the current password is throttled like guessing it at login
+	if err := h.checkThrottle(c, user.Username, user.ID); err != nil {
+		return err
+	}
+	err = auth.ChangePassword(c.UserContext(), h.Users, h.Policy, user.ID, req.CurrentPassword, req.NewPassword)
+	if err == nil {
+		// Whoever knew the old password is logged out everywhere else
+		if err = h.Sessions.RevokeAllForUser(c, user.ID, currentSessionID(c)); err == nil {
+			err = h.Tokens.RevokeAllForUser(c.UserContext(), user.ID)
+		}
+	}
+	if errors.Is(err, auth.ErrWrongPassword) {
+		h.recordFailure(c, user.Username, user.ID, auth.LoginFailureBadPassword)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) exportAccount(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	export, err := h.Accounts.Export(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	if export == nil {
+		return domain.ErrUserNotFound
+	}
+
+	c.Attachment(fmt.Sprintf("go-commerce-account-%d.json", userID))
+	return c.JSON(export)
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+	Confirm  string `json:"confirm"` // The username, for accounts without a password
+}
+
+func (h *AuthHandler) deleteAccount(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.Users)
+	if err != nil {
+		return err
+	}
+
+	var req deleteAccountRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	if user.Password != "" {
+		if err := h.checkThrottle(c, user.Username, user.ID); err != nil {
+			return err
+		}
+		if !auth.CheckPasswordHash(req.Password, user.Password) {
+			h.recordFailure(c, user.Username, user.ID, auth.LoginFailureBadPassword)
+			return auth.ErrWrongPassword
+		}
+	} else if req.Confirm != user.Username {
+		return domain.Invalid("confirm", "confirmation_mismatch", "Confirm by sending your username")
+	}
+
+	if err := h.Accounts.Delete(c.UserContext(), user.ID); err != nil {
+		return err
+	}
+
+	if currentAuthMethod(c) == AuthMethodSession {
+		if err := h.Sessions.Logout(c); err != nil {
+			return err
+		}
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) listSessions(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	list, err := h.Sessions.List(c, userID, currentSessionID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(list)
+}
+
+func (h *AuthHandler) revokeOtherSessions(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	if err := h.Sessions.RevokeAllForUser(c, userID, currentSessionID(c)); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) revokeSession(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("session")
+	}
+
+	if err := h.Sessions.Revoke(c, userID, id); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
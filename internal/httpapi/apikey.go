@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/domain"
+)
+
+// APIKeyHandler serves the personal API key endpoints. Keys are managed from
+// a logged-in session or access token; a key cannot be used to create or
+// list keys.
+type APIKeyHandler struct {
+	keys  domain.APIKeyRepository
+	users domain.UserRepository
+}
+
+// NewAPIKeyHandler creates the API key handler
+func NewAPIKeyHandler(keys domain.APIKeyRepository, users domain.UserRepository) *APIKeyHandler {
+	return &APIKeyHandler{keys: keys, users: users}
+}
+
+// Register adds the API key routes
+func (h *APIKeyHandler) Register(router fiber.Router) {
+	router.Get("/keys", h.list)
+	router.Post("/keys", h.create)
+	router.Delete("/keys/:id", h.revoke)
+}
+
+func (h *APIKeyHandler) list(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return err
+	}
+
+	keys, err := h.keys.ListByUser(c.UserContext(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(keys)
+}
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"` // Optional, RFC 3339
+}
+
+func (h *APIKeyHandler) create(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return err
+	}
+
+	var req createAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	key, err := auth.CreateAPIKey(c.UserContext(), h.keys, user, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(key)
+}
+
+func (h *APIKeyHandler) revoke(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.users)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("key")
+	}
+
+	err = auth.RevokeAPIKey(c.UserContext(), h.keys, user, id)
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
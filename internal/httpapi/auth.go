@@ -0,0 +1,428 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/domain"
+	"go-commerce/internal/metrics"
+)
+
+// AuthDependencies are what AuthHandler needs to log users in and manage
+// their accounts
+type AuthDependencies struct {
+	Users      domain.UserRepository
+	Profiles   domain.ProfileRepository
+	TwoFactor  domain.TwoFactorRepository
+	Identities domain.IdentityRepository
+	Accounts   domain.AccountRepository
+	Sessions   *Sessions
+	Tokens     *auth.APITokens
+	Throttle   *auth.LoginThrottle
+	Emails     *auth.AccountEmails
+	Policy     *auth.CredentialPolicy
+	OIDC       *auth.OIDCLogin
+	PublicURL  string // The shop's configured address without a trailing slash
+}
+
+// Login errors
+var (
+	errInvalidCredentials = domain.Unauthorized("invalid_credentials", "Invalid credentials")
+	errNoPendingLogin     = domain.Unauthorized("no_pending_login", "Log in with your password first")
+)
+
+// AuthHandler serves registration, login by password, bearer token or
+// OpenID Connect, two-factor authentication and account self-service
+type AuthHandler struct {
+	AuthDependencies
+}
+
+// NewAuthHandler creates the authentication handler
+func NewAuthHandler(deps AuthDependencies) *AuthHandler {
+	return &AuthHandler{AuthDependencies: deps}
+}
+
+// Register adds the authentication and account routes
+func (h *AuthHandler) Register(router fiber.Router) {
+	router.Get("/me", RequireScope(domain.ScopeReadProfile), h.me)
+	router.Post("/register", h.register)
+	router.Post("/login", h.login)
+	router.Post("/login/2fa", h.loginSecondFactor)
+	router.Post("/logout", h.logout)
+
+	// Bearer token endpoints for clients that do not keep cookies, such as
+	// the mobile app. Accounts with two-factor authentication send the code
+	// along with the password.
+	router.Post("/token", h.issueToken)
+	router.Post("/token/refresh", h.refreshToken)
+	router.Post("/token/revoke", h.revokeToken)
+
+	h.registerOIDC(router)
+	h.registerTwoFactor(router)
+	h.registerEmail(router)
+	h.registerAccount(router)
+}
+
+func (h *AuthHandler) me(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		sess, err := h.Sessions.Get(c)
+		if err != nil {
+			return err
+		}
+		_, pending := sess.Get("pendingUserID").(int)
+		return c.JSON(fiber.Map{"loggedIn": false, "twoFactorPending": pending})
+	}
+
+	return c.JSON(fiber.Map{"loggedIn": true, "userID": userID, "authMethod": currentAuthMethod(c)})
+}
+
+type authRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"` // Optional, registration only
+}
+
+func (h *AuthHandler) register(c *fiber.Ctx) error {
+	var req authRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	username := domain.NormalizeUsername(req.Username)
+	var email string
+	var emailErr error
+	if strings.TrimSpace(req.Email) != "" {
+		email, emailErr = domain.NormalizeEmail(req.Email)
+	}
+	// Every field at fault is reported at once
+	if err := domain.Validation(
+		h.Policy.ValidateUsername(username),
+		h.Policy.ValidatePassword(username, req.Password),
+		emailErr,
+	); err != nil {
+		return err
+	}
+
+	if email != "" {
+		existing, err := h.Users.GetByEmail(c.UserContext(), email)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return domain.ErrEmailTaken
+		}
+	}
+
+	user, err := auth.CreateUser(c.UserContext(), h.Users, username, req.Password)
+	if err != nil {
+		return err
+	}
+
+	if email != "" {
+		if err := h.Users.SetEmail(c.UserContext(), user.ID, email); err != nil {
+			return err
+		}
+		// The account exists either way; the user can ask for another email
+		if err := h.Emails.SendVerification(c.UserContext(), user.ID); err != nil {
+			slog.ErrorContext(c.UserContext(), "sending verification email", "user_id", user.ID, "error", err)
+		}
+	}
+
+	return c.JSON(user)
+}
+
+// recordFailure counts a failed login towards the throttle and audit log
+func (h *AuthHandler) recordFailure(c *fiber.Ctx, username string, userID int, reason string) {
+	metrics.LoginFailures.WithLabelValues(reason).Inc()
+	if err := h.Throttle.RecordFailure(c.UserContext(), username, c.IP(), c.Get(fiber.HeaderUserAgent), userID, reason); err != nil {
+		slog.ErrorContext(c.UserContext(), "recording failed login", "error", err)
+	}
+}
+
+// clearFailures resets the lockout for a username once a login has fully
+// succeeded, second factor included
+func (h *AuthHandler) clearFailures(c *fiber.Ctx, username string) {
+	if err := h.Throttle.RecordSuccess(c.UserContext(), username); err != nil {
+		slog.ErrorContext(c.UserContext(), "clearing failed logins", "error", err)
+	}
+}
+
+// checkThrottle rejects the request if too many logins have failed for
+// the username or the client's IP address
+func (h *AuthHandler) checkThrottle(c *fiber.Ctx, username string, userID int) error {
+	wait, err := h.Throttle.Check(c.UserContext(), username, c.IP())
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		h.recordFailure(c, username, userID, auth.LoginFailureThrottled)
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, "Too many failed login attempts, try again later")
+	}
+	return nil
+}
+
+// checkPassword authenticates a username and password, subject to the
+// login throttle, for both cookie and token logins. A correct password does
+// not clear the failures counted so far: for accounts with two-factor
+// authentication that lockout also guards the code, so callers clear it
+// only once the login is complete.
+func (h *AuthHandler) checkPassword(c *fiber.Ctx, username, password string) (*domain.User, error) {
+	if err := h.checkThrottle(c, username, 0); err != nil {
+		return nil, err
+	}
+
+	user, ok, err := auth.Authenticate(c.UserContext(), h.Users, username, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		userID, reason := 0, auth.LoginFailureUnknownUser
+		if user != nil {
+			userID, reason = user.ID, auth.LoginFailureBadPassword
+		}
+		h.recordFailure(c, username, userID, reason)
+		return nil, errInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// checkSecondFactor verifies a two-factor code, counting wrong codes
+// towards the same lockout as wrong passwords
+func (h *AuthHandler) checkSecondFactor(c *fiber.Ctx, user *domain.User, code string) error {
+	if err := h.checkThrottle(c, user.Username, user.ID); err != nil {
+		return err
+	}
+
+	err := auth.VerifySecondFactor(c.UserContext(), h.TwoFactor, user.ID, code)
+	if errors.Is(err, auth.ErrInvalidTwoFactorCode) || errors.Is(err, auth.ErrTwoFactorNotEnabled) {
+		h.recordFailure(c, user.Username, user.ID, auth.LoginFailureBadTOTP)
+		return auth.ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		return err
+	}
+	h.clearFailures(c, user.Username)
+
+	return nil
+}
+
+// Outcomes of startSession
+const (
+	loginComplete          = ""
+	loginTwoFactorRequired = "twoFactorRequired"
+	loginTwoFactorSetup    = "twoFactorSetupRequired"
+)
+
+// startSession logs a user who proved their identity into the session
+// cookie. With two-factor authentication this only earns a partial
+// session, which is upgraded by /api/login/2fa (or, for users who must
+// enroll first, by /api/2fa/confirm).
+func (h *AuthHandler) startSession(c *fiber.Ctx, user *domain.User) (string, error) {
+	twoFactorEnabled, err := auth.TwoFactorEnabled(c.UserContext(), h.TwoFactor, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	sess, err := h.Sessions.Get(c)
+	if err != nil {
+		return "", err
+	}
+
+	if !twoFactorEnabled && !user.RequiresTwoFactor() {
+		if err := h.Sessions.Start(c, sess, user.ID); err != nil {
+			return "", err
+		}
+		return loginComplete, nil
+	}
+
+	outcome := loginTwoFactorRequired
+	if !twoFactorEnabled {
+		outcome = loginTwoFactorSetup
+	}
+	sess.Delete("userID")
+	sess.Set("pendingUserID", user.ID)
+	sess.Set("pendingSince", time.Now().Unix())
+	if err := sess.Save(); err != nil {
+		return "", err
+	}
+
+	return outcome, nil
+}
+
+// pendingLogin returns the user who gave a correct password but has not
+// completed two-factor authentication yet, or 0 if there is none
+func pendingLogin(sess *session.Session) int {
+	userID, ok := sess.Get("pendingUserID").(int)
+	if !ok {
+		return 0
+	}
+	since, _ := sess.Get("pendingSince").(int64)
+	if time.Since(time.Unix(since, 0)) > auth.TwoFactorLoginTimeout {
+		return 0
+	}
+	return userID
+}
+
+// completeLogin upgrades a partial session to a full one
+func (h *AuthHandler) completeLogin(c *fiber.Ctx, sess *session.Session, userID int) error {
+	sess.Delete("pendingUserID")
+	sess.Delete("pendingSince")
+	return h.Sessions.Start(c, sess, userID)
+}
+
+func (h *AuthHandler) login(c *fiber.Ctx) error {
+	var req authRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	user, err := h.checkPassword(c, req.Username, req.Password)
+	if err != nil {
+		return err
+	}
+
+	outcome, err := h.startSession(c, user)
+	if err != nil {
+		return err
+	}
+	switch outcome {
+	case loginTwoFactorSetup:
+		return c.JSON(fiber.Map{"message": "Two-factor authentication must be set up", "twoFactorSetupRequired": true})
+	case loginTwoFactorRequired:
+		return c.JSON(fiber.Map{"message": "Two-factor code required", "twoFactorRequired": true})
+	}
+	h.clearFailures(c, user.Username)
+
+	return c.JSON(fiber.Map{"message": "Login successful"})
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"` // A TOTP code or, at login, a recovery code
+}
+
+func (h *AuthHandler) loginSecondFactor(c *fiber.Ctx) error {
+	var req twoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	sess, err := h.Sessions.Get(c)
+	if err != nil {
+		return err
+	}
+	userID := pendingLogin(sess)
+	if userID == 0 {
+		return errNoPendingLogin
+	}
+
+	user, err := h.Users.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errNoPendingLogin
+	}
+
+	if err := h.checkSecondFactor(c, user, req.Code); err != nil {
+		return err
+	}
+
+	if err := h.completeLogin(c, sess, user.ID); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": "Login successful"})
+}
+
+func (h *AuthHandler) logout(c *fiber.Ctx) error {
+	if err := h.Sessions.Logout(c); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type tokenRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func (h *AuthHandler) issueToken(c *fiber.Ctx) error {
+	var req tokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	user, err := h.checkPassword(c, req.Username, req.Password)
+	if err != nil {
+		return err
+	}
+
+	twoFactorEnabled, err := auth.TwoFactorEnabled(c.UserContext(), h.TwoFactor, user.ID)
+	if err != nil {
+		return err
+	}
+	if twoFactorEnabled {
+		if req.Code == "" {
+			return domain.Unauthorized("two_factor_code_required", "Two-factor code required")
+		}
+		if err := h.checkSecondFactor(c, user, req.Code); err != nil {
+			return err
+		}
+	} else if user.RequiresTwoFactor() {
+		return domain.Forbidden("two_factor_setup_required", "Two-factor authentication must be set up before using API tokens")
+	}
+	// checkSecondFactor has already cleared them for accounts with a code
+	if !twoFactorEnabled {
+		h.clearFailures(c, user.Username)
+	}
+
+	tokens, err := h.Tokens.Issue(c.UserContext(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(tokens)
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (h *AuthHandler) refreshToken(c *fiber.Ctx) error {
+	var req refreshTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	tokens, err := h.Tokens.Refresh(c.UserContext(), req.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(tokens)
+}
+
+func (h *AuthHandler) revokeToken(c *fiber.Ctx) error {
+	var req refreshTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	if err := h.Tokens.Revoke(c.UserContext(), req.RefreshToken); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
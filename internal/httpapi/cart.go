@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/domain"
+	"go-commerce/internal/metrics"
+)
+
+// CartHandler serves shopping carts
+type CartHandler struct {
+	carts domain.CartRepository
+}
+
+// NewCartHandler creates the cart handler
+func NewCartHandler(carts domain.CartRepository) *CartHandler {
+	return &CartHandler{carts: carts}
+}
+
+// Register adds the cart routes
+func (h *CartHandler) Register(router fiber.Router) {
+	router.Post("/cart", RequireScope(domain.ScopeWriteCart), h.create)
+	router.Get("/cart/:id", RequireScope(domain.ScopeWriteCart), h.get)
+	router.Post("/cart/:id/items", RequireScope(domain.ScopeWriteCart), h.addItem)
+}
+
+func (h *CartHandler) create(c *fiber.Ctx) error {
+	id, err := h.carts.Create(c.UserContext())
+	if err != nil {
+		return err
+	}
+	metrics.CartsCreated.Inc()
+	return c.JSON(fiber.Map{"id": id})
+}
+
+func (h *CartHandler) get(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("cart")
+	}
+
+	cart, err := h.carts.Get(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(cart)
+}
+
+type addToCartRequest struct {
+	ProductID int `json:"productId"`
+	Quantity  int `json:"quantity"`
+}
+
+func (h *CartHandler) addItem(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("cart")
+	}
+
+	var req addToCartRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	if err := h.carts.AddItem(c.UserContext(), id, req.ProductID, req.Quantity); err != nil {
+		return err
+	}
+	// Counters cannot go down; a negative quantity takes items out
+	if req.Quantity > 0 {
+		metrics.CartItemsAdded.Add(float64(req.Quantity))
+	}
+
+	return c.SendStatus(fiber.StatusCreated)
+}
@@ -0,0 +1,186 @@
+package httpapi
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/domain"
+)
+
+// CatalogHandler serves the products and categories and lets staff rename them
+type CatalogHandler struct {
+	products   domain.ProductRepository
+	categories domain.CategoryRepository
+	slugs      domain.SlugRepository
+	users      domain.UserRepository
+	twoFactor  domain.TwoFactorRepository
+}
+
+// NewCatalogHandler creates the catalog handler
+func NewCatalogHandler(products domain.ProductRepository, categories domain.CategoryRepository, slugs domain.SlugRepository, users domain.UserRepository, twoFactor domain.TwoFactorRepository) *CatalogHandler {
+	return &CatalogHandler{products: products, categories: categories, slugs: slugs, users: users, twoFactor: twoFactor}
+}
+
+// Register adds the catalog routes
+func (h *CatalogHandler) Register(router fiber.Router) {
+	// Products endpoints
+	router.Get("/products", RequireScope(domain.ScopeReadCatalog), h.listProducts)
+	router.Get("/products/by-slug/:slug", RequireScope(domain.ScopeReadCatalog), h.getProductBySlug)
+	router.Get("/products/:id", RequireScope(domain.ScopeReadCatalog), h.getProduct)
+
+	// Categories endpoints
+	router.Get("/categories", RequireScope(domain.ScopeReadCatalog), h.listCategories)
+	router.Get("/categories/by-slug/:slug", RequireScope(domain.ScopeReadCatalog), h.getCategoryBySlug)
+
+	// Catalog admin endpoints. Renaming changes the slug; the old one
+	// redirects to the new one.
+	router.Put("/admin/products/:id/name", RequireScope(domain.ScopeAdminCatalog), h.renameProduct)
+	router.Put("/admin/categories/:id/name", RequireScope(domain.ScopeAdminCatalog), h.renameCategory)
+}
+
+func (h *CatalogHandler) listProducts(c *fiber.Ctx) error {
+	searchTerm := c.Query("search")
+	categoryID := c.Query("category")
+	sortBy := c.Query("sort")
+	if sortBy != domain.ProductSortDefault && sortBy != domain.ProductSortRating {
+		return domain.Invalid("sort", "invalid_sort", "Invalid sort order")
+	}
+	products, err := h.products.List(c.UserContext(), searchTerm, categoryID, sortBy)
+	if err != nil {
+		return err
+	}
+	return c.JSON(products)
+}
+
+func (h *CatalogHandler) getProductBySlug(c *fiber.Ctx) error {
+	slug, err := url.PathUnescape(c.Params("slug"))
+	if err != nil {
+		return domain.BadRequest("invalid_slug", "Invalid product slug")
+	}
+
+	id, current, err := h.slugs.Resolve(c.UserContext(), domain.SlugEntityProduct, slug)
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		return domain.ErrProductNotFound
+	}
+	if current != slug {
+		return c.Redirect("/api/products/by-slug/"+url.PathEscape(current), fiber.StatusMovedPermanently)
+	}
+
+	return h.sendProduct(c, id)
+}
+
+func (h *CatalogHandler) getProduct(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("product")
+	}
+
+	return h.sendProduct(c, id)
+}
+
+// sendProduct responds with the product with the ID
+func (h *CatalogHandler) sendProduct(c *fiber.Ctx, id int) error {
+	product, err := h.products.Get(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(product)
+}
+
+func (h *CatalogHandler) listCategories(c *fiber.Ctx) error {
+	categories, err := h.categories.List(c.UserContext())
+	if err != nil {
+		return err
+	}
+	return c.JSON(categories)
+}
+
+func (h *CatalogHandler) getCategoryBySlug(c *fiber.Ctx) error {
+	slug, err := url.PathUnescape(c.Params("slug"))
+	if err != nil {
+		return domain.BadRequest("invalid_slug", "Invalid category slug")
+	}
+
+	id, current, err := h.slugs.Resolve(c.UserContext(), domain.SlugEntityCategory, slug)
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		return domain.ErrCategoryNotFound
+	}
+	if current != slug {
+		return c.Redirect("/api/categories/by-slug/"+url.PathEscape(current), fiber.StatusMovedPermanently)
+	}
+
+	category, err := h.categories.Get(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(category)
+}
+
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+// parseRename reads and checks the new name of a rename request
+func parseRename(c *fiber.Ctx) (string, error) {
+	var req renameRequest
+	if err := c.BodyParser(&req); err != nil {
+		return "", errInvalidBody
+	}
+	return domain.NormalizeCatalogName(req.Name)
+}
+
+func (h *CatalogHandler) renameProduct(c *fiber.Ctx) error {
+	if _, err := requireStaff(c, h.users, h.twoFactor); err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("product")
+	}
+
+	name, err := parseRename(c)
+	if err != nil {
+		return err
+	}
+
+	product, err := h.products.Rename(c.UserContext(), id, name)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(product)
+}
+
+func (h *CatalogHandler) renameCategory(c *fiber.Ctx) error {
+	if _, err := requireStaff(c, h.users, h.twoFactor); err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("category")
+	}
+
+	name, err := parseRename(c)
+	if err != nil {
+		return err
+	}
+
+	category, err := h.categories.Rename(c.UserContext(), id, name)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(category)
+}
@@ -0,0 +1,187 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/domain"
+	"go-commerce/internal/storage"
+)
+
+// newCatalogApp serves the catalog routes from a fresh SQLite database with
+// the sample data. Requests act as the user in the X-Test-User header.
+func newCatalogApp(t *testing.T) (*fiber.App, *storage.UserRepository) {
+	t.Helper()
+	db, err := storage.Open(storage.DialectSQLite, filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.SeedSampleData(context.Background()); err != nil {
+		t.Fatalf("seeding database: %v", err)
+	}
+
+	users := storage.NewUserRepository(db)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		if username := c.Get("X-Test-User"); username != "" {
+			user, err := users.GetByUsername(c.UserContext(), username)
+			if err != nil || user == nil {
+				t.Errorf("looking up test user %q: %v", username, err)
+				return fiber.ErrInternalServerError
+			}
+			c.Locals(localUserID, user.ID)
+		}
+		return c.Next()
+	})
+	NewCatalogHandler(storage.NewProductRepository(db), storage.NewCategoryRepository(db), storage.NewSlugRepository(db),
+		users, storage.NewTwoFactorRepository(db)).Register(app)
+	return app, users
+}
+
+// createUser adds a user with a role
+func createUser(t *testing.T, users *storage.UserRepository, username, role string) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := users.Create(ctx, username, "unused"); err != nil {
+		t.Fatalf("creating user %q: %v", username, err)
+	}
+	if err := users.SetRole(ctx, username, role); err != nil {
+		t.Fatalf("setting role of %q: %v", username, err)
+	}
+}
+
+func request(t *testing.T, app *fiber.App, method, target, user, body string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestRenameRedirectsOldSlug(t *testing.T) {
+	tests := []struct {
+		name      string
+		renameURL string
+		newName   string
+		slug      string
+		oldURL    string
+		location  string
+	}{
+		{
+			name:      "product",
+			renameURL: "/admin/products/1/name",
+			newName:   "MacBook Pro M4",
+			slug:      "macbook-pro-m4",
+			oldURL:    "/products/by-slug/macbook-pro",
+			location:  "/api/products/by-slug/macbook-pro-m4",
+		},
+		{
+			name:      "category",
+			renameURL: "/admin/categories/1/name",
+			newName:   "  Notebooks ",
+			slug:      "notebooks",
+			oldURL:    "/categories/by-slug/laptops",
+			location:  "/api/categories/by-slug/notebooks",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, users := newCatalogApp(t)
+			createUser(t, users, "staffer", domain.RoleStaff)
+
+			resp := request(t, app, fiber.MethodPut, tt.renameURL, "staffer", `{"name":`+quote(tt.newName)+`}`)
+			if resp.StatusCode != fiber.StatusOK {
+				t.Fatalf("rename: status %d, body %s", resp.StatusCode, readBody(t, resp))
+			}
+			var renamed struct {
+				Name string `json:"name"`
+				Slug string `json:"slug"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&renamed); err != nil {
+				t.Fatalf("decoding rename response: %v", err)
+			}
+			if renamed.Name != strings.TrimSpace(tt.newName) || renamed.Slug != tt.slug {
+				t.Errorf("renamed to %q with slug %q", renamed.Name, renamed.Slug)
+			}
+
+			resp = request(t, app, fiber.MethodGet, tt.oldURL, "", "")
+			if resp.StatusCode != fiber.StatusMovedPermanently {
+				t.Fatalf("old slug: status %d, want %d", resp.StatusCode, fiber.StatusMovedPermanently)
+			}
+			if got := resp.Header.Get(fiber.HeaderLocation); got != tt.location {
+				t.Errorf("old slug redirects to %q, want %q", got, tt.location)
+			}
+
+			resp = request(t, app, fiber.MethodGet, strings.TrimPrefix(tt.location, "/api"), "", "")
+			if resp.StatusCode != fiber.StatusOK {
+				t.Errorf("new slug: status %d, want %d", resp.StatusCode, fiber.StatusOK)
+			}
+		})
+	}
+}
+
+func TestRenameRejected(t *testing.T) {
+	app, users := newCatalogApp(t)
+	createUser(t, users, "staffer", domain.RoleStaff)
+	createUser(t, users, "shopper", domain.RoleCustomer)
+
+	tests := []struct {
+		name   string
+		target string
+		user   string
+		body   string
+		status int
+	}{
+		{"anonymous", "/admin/products/1/name", "", `{"name":"Cheap MacBook"}`, fiber.StatusUnauthorized},
+		{"customer", "/admin/products/1/name", "shopper", `{"name":"Cheap MacBook"}`, fiber.StatusForbidden},
+		{"blank name", "/admin/products/1/name", "staffer", `{"name":"  "}`, fiber.StatusBadRequest},
+		{"unknown product", "/admin/products/999/name", "staffer", `{"name":"Ghost"}`, fiber.StatusNotFound},
+		{"unknown category", "/admin/categories/999/name", "staffer", `{"name":"Ghost"}`, fiber.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := request(t, app, fiber.MethodPut, tt.target, tt.user, tt.body)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d; body %s", resp.StatusCode, tt.status, readBody(t, resp))
+			}
+		})
+	}
+
+	// Rejected renames leave the slug alone
+	resp := request(t, app, fiber.MethodGet, "/products/by-slug/macbook-pro", "", "")
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("slug after rejected renames: status %d, want %d", resp.StatusCode, fiber.StatusOK)
+	}
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	return string(body)
+}
@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+
+	"go-commerce/internal/domain"
+)
+
+// The cookie the CSRF token is handed to the browser in and the header it
+// must be sent back in
+const (
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// CSRF protects cookie-authenticated requests against cross-site request
+// forgery. The token for a session is an HMAC of the session ID, so it needs
+// no storage and changes whenever the session ID does, as at login. It is
+// kept in a cookie that scripts can read, and requests that change anything
+// must repeat it in the X-CSRF-Token header, which another site cannot do.
+//
+// Requests without a session cookie carry no credentials to abuse, and
+// requests with an Authorization header are authenticated by it alone, so
+// both are exempt.
+type CSRF struct {
+	secret        []byte
+	sessionCookie string
+	secure        bool
+}
+
+// NewCSRF creates the CSRF protection for sessions kept in the named cookie.
+// secret should be the server's persistent CSRF secret. secure marks the
+// token cookie as HTTPS-only, like the session cookie.
+func NewCSRF(secret []byte, sessionCookie string, secure bool) *CSRF {
+	return &CSRF{secret: secret, sessionCookie: sessionCookie, secure: secure}
+}
+
+// Token returns the CSRF token for a session ID
+func (x *CSRF) Token(sessionID string) string {
+	mac := hmac.New(sha256.New, x.secret)
+	mac.Write([]byte("csrf:" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Middleware rejects unsafe requests made with a session cookie but without
+// the matching token, and keeps the token cookie in step with the session
+// cookie, including sessions started or ended by the request.
+func (x *CSRF) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sessionID := c.Cookies(x.sessionCookie)
+
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		default:
+			if sessionID != "" && c.Get(fiber.HeaderAuthorization) == "" {
+				sent := c.Get(CSRFHeaderName)
+				if sent == "" || !hmac.Equal([]byte(sent), []byte(x.Token(sessionID))) {
+					return domain.Forbidden("invalid_csrf_token", "Missing or invalid CSRF token")
+				}
+			}
+		}
+
+		err := c.Next()
+
+		// The handler may have set a new session cookie or deleted it
+		if raw := c.Response().Header.PeekCookie(x.sessionCookie); raw != nil {
+			cookie := fasthttp.AcquireCookie()
+			if cookie.ParseBytes(raw) == nil {
+				sessionID = string(cookie.Value())
+				if cookie.MaxAge() < 0 || (!cookie.Expire().IsZero() && cookie.Expire().Before(time.Now())) {
+					sessionID = ""
+				}
+			}
+			fasthttp.ReleaseCookie(cookie)
+		}
+
+		token := ""
+		if sessionID != "" {
+			token = x.Token(sessionID)
+		}
+		if c.Cookies(CSRFCookieName) != token {
+			cookie := &fiber.Cookie{
+				Name:     CSRFCookieName,
+				Value:    token,
+				Path:     "/",
+				Secure:   x.secure,
+				HTTPOnly: false, // Read by the storefront's scripts
+				SameSite: fiber.CookieSameSiteLaxMode,
+			}
+			if token == "" {
+				cookie.Expires = time.Now().Add(-time.Minute)
+			}
+			c.Cookie(cookie)
+		}
+
+		return err
+	}
+}
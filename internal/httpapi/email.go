@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/domain"
+)
+
+var errPasswordRequiredForEmail = domain.Forbidden("password_required",
+	"Set a password with a password reset email before changing your email address")
+
+// registerEmail adds the email verification and password reset endpoints
+func (h *AuthHandler) registerEmail(router fiber.Router) {
+	router.Post("/email/verification", h.sendVerification)
+	// Opened from the link in the verification email
+	router.Get("/email/verify", h.verifyEmail)
+	router.Post("/password/forgot", h.forgotPassword)
+	router.Post("/password/reset", h.resetPassword)
+}
+
+type emailVerificationRequest struct {
+	Email           string `json:"email"`           // Optional; replaces the address on the account
+	CurrentPassword string `json:"currentPassword"` // Required with Email on accounts with a password
+}
+
+func (h *AuthHandler) sendVerification(c *fiber.Ctx) error {
+	user, err := currentUser(c, h.Users)
+	if err != nil {
+		return err
+	}
+
+	var req emailVerificationRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return errInvalidBody
+		}
+	}
+
+	if req.Email != "" {
+		if err := h.checkEmailChange(c, user, req.CurrentPassword); err != nil {
+			return err
+		}
+		if err := h.Users.SetEmail(c.UserContext(), user.ID, req.Email); err != nil {
+			return err
+		}
+	}
+
+	err = h.Emails.SendVerification(c.UserContext(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// checkEmailChange makes sure the owner of the account, not just whoever
+// holds the session, is changing its address: the address is where password
+// reset links go. Accounts without a password may only add a first address.
+func (h *AuthHandler) checkEmailChange(c *fiber.Ctx, user *domain.User, currentPassword string) error {
+	if user.Password == "" {
+		profile, err := h.Profiles.Get(c.UserContext(), user.ID)
+		if err != nil {
+			return err
+		}
+		if profile != nil && profile.Email != "" {
+			return errPasswordRequiredForEmail
+		}
+		return nil
+	}
+
+	if err := h.checkThrottle(c, user.Username, user.ID); err != nil {
+		return err
+	}
+	if !auth.CheckPasswordHash(currentPassword, user.Password) {
+		h.recordFailure(c, user.Username, user.ID, auth.LoginFailureBadPassword)
+		return auth.ErrWrongPassword
+	}
+	return nil
+}
+
+func (h *AuthHandler) verifyEmail(c *fiber.Ctx) error {
+	err := h.Emails.VerifyEmail(c.UserContext(), c.Query("token"))
+	if err != nil {
+		return err
+	}
+
+	return c.Redirect("/?emailVerified=1")
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+func (h *AuthHandler) forgotPassword(c *fiber.Ctx) error {
+	var req forgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	wait, err := h.Emails.RequestPasswordReset(c.UserContext(), req.Email, c.IP())
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, "Too many password reset requests, try again later")
+	}
+
+	// Same response whether or not the address belongs to an account; the
+	// email is sent in the background
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (h *AuthHandler) resetPassword(c *fiber.Ctx) error {
+	var req resetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	userID, err := h.Emails.ResetPassword(c.UserContext(), req.Token, req.Password)
+	if err == nil {
+		// The password may have been reset because the account was taken over
+		if err = h.Sessions.RevokeAllForUser(c, userID, ""); err == nil {
+			err = h.Tokens.RevokeAllForUser(c.UserContext(), userID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
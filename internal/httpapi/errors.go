@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/domain"
+	"go-commerce/internal/logging"
+)
+
+// Errors the handlers share
+var (
+	errInvalidBody = domain.BadRequest("invalid_body", "Invalid request body")
+	errNotLoggedIn = domain.Unauthorized("not_logged_in", "Not logged in")
+)
+
+// invalidID rejects a malformed ID in the path, e.g. invalidID("cart")
+func invalidID(entity string) error {
+	return domain.BadRequest("invalid_id", "Invalid "+entity+" ID")
+}
+
+// internalErrorCode is the code of every server error. Their details are
+// logged, not sent.
+const internalErrorCode = "internal_error"
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error. Code is stable and meant for programs;
+// Message is meant for people and may change.
+type ErrorBody struct {
+	Code      string              `json:"code"`
+	Message   string              `json:"message"`
+	Fields    []domain.FieldError `json:"fields,omitempty"`
+	RequestID string              `json:"requestId,omitempty"`
+}
+
+// kindStatus is the HTTP status of each kind of domain error
+var kindStatus = map[domain.ErrorKind]int{
+	domain.KindValidation:   fiber.StatusBadRequest,
+	domain.KindUnauthorized: fiber.StatusUnauthorized,
+	domain.KindForbidden:    fiber.StatusForbidden,
+	domain.KindNotFound:     fiber.StatusNotFound,
+	domain.KindConflict:     fiber.StatusConflict,
+}
+
+// ErrorHandler sends an error as JSON. Domain errors get the status of their
+// kind and keep their code and field details, and errors made with
+// fiber.NewError get a code named after their status, such as "not_found".
+// Any other error is a server error: it is logged and the client only learns
+// the request ID, so database and other internal messages never reach it.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	ctx := c.UserContext()
+	status := fiber.StatusInternalServerError
+	body := ErrorBody{Code: internalErrorCode, RequestID: logging.RequestID(ctx)}
+
+	var domainErr *domain.Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &domainErr):
+		status = kindStatus[domainErr.Kind]
+		if status == 0 {
+			status = fiber.StatusBadRequest
+		}
+		body.Code = domainErr.Code
+		body.Message = domainErr.Message
+		body.Fields = domainErr.Fields
+	case errors.As(err, &fiberErr):
+		status = fiberErr.Code
+		body.Code = statusCode(status)
+		body.Message = fiberErr.Message
+	}
+
+	if status >= fiber.StatusInternalServerError {
+		slog.ErrorContext(ctx, "request failed", "status", status, "error", err.Error())
+		body.Message = http.StatusText(status)
+	}
+
+	return c.Status(status).JSON(ErrorResponse{Error: body})
+}
+
+// statusCode turns an HTTP status into an error code, e.g. 404 into
+// "not_found"
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return internalErrorCode
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
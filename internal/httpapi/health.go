@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// healthCheckTimeout bounds each dependency check made for /readyz
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck tests one dependency the server needs to handle requests
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// BuildInfo describes the running build for /version
+type BuildInfo struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+// HealthHandler serves the endpoints load balancers and orchestrators poll:
+// /healthz while the process is alive, /readyz while it should get requests
+// and /version. The server is not ready until it is listening nor once
+// shutdown begins, whatever its dependencies report.
+type HealthHandler struct {
+	ready  atomic.Bool
+	checks []HealthCheck
+	build  BuildInfo
+}
+
+// NewHealthHandler creates the health handler. It is not ready until
+// SetReady is called.
+func NewHealthHandler(build BuildInfo, checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{checks: checks, build: build}
+}
+
+// SetReady turns readiness on or off
+func (h *HealthHandler) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// Register adds the health endpoints
+func (h *HealthHandler) Register(router fiber.Router) {
+	router.Get("/healthz", h.healthz)
+	router.Get("/readyz", h.readyz)
+	router.Get("/version", h.version)
+}
+
+func (h *HealthHandler) healthz(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// checkResult is the outcome of one check reported by /readyz. Anyone can
+// poll it, so failures give a fixed reason and the error is only logged.
+type checkResult struct {
+	Status string `json:"status"` // ok or failed
+	Error  string `json:"error,omitempty"`
+}
+
+func (h *HealthHandler) readyz(c *fiber.Ctx) error {
+	results := make(map[string]checkResult, len(h.checks)+1)
+	ready := h.ready.Load()
+	if ready {
+		results["server"] = checkResult{Status: "ok"}
+	} else {
+		results["server"] = checkResult{Status: "failed", Error: "not accepting requests"}
+	}
+
+	for _, check := range h.checks {
+		ctx, cancel := context.WithTimeout(c.UserContext(), healthCheckTimeout)
+		err := check.Check(ctx)
+		cancel()
+		if err != nil {
+			ready = false
+			slog.WarnContext(c.UserContext(), "readiness check failed", "check", check.Name, "error", err)
+			reason := "check failed"
+			if errors.Is(err, context.DeadlineExceeded) {
+				reason = "timed out"
+			}
+			results[check.Name] = checkResult{Status: "failed", Error: reason}
+		} else {
+			results[check.Name] = checkResult{Status: "ok"}
+		}
+	}
+
+	if !ready {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "unavailable", "checks": results})
+	}
+	return c.JSON(fiber.Map{"status": "ready", "checks": results})
+}
+
+func (h *HealthHandler) version(c *fiber.Ctx) error {
+	return c.JSON(h.build)
+}
@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
+
+	"go-commerce/internal/metrics"
+)
+
+// unmatchedRoute labels requests for paths no route serves, so scans for
+// random paths do not make a series each
+const unmatchedRoute = "unmatched"
+
+// routeNamer names the route pattern that handled a request, e.g.
+// /api/cart/:id. Requests no route serves are left with the route of the
+// last middleware they passed, so a 404 is only named after its route if
+// that has handlers of its own.
+type routeNamer struct {
+	once      sync.Once
+	endpoints map[string]bool
+}
+
+// name returns the route of a handled request, or unmatchedRoute
+func (n *routeNamer) name(c *fiber.Ctx, method string) string {
+	// All routes are registered before the first request
+	n.once.Do(func() {
+		n.endpoints = make(map[string]bool)
+		for _, route := range c.App().GetRoutes(true) {
+			n.endpoints[route.Method+" "+route.Path] = true
+		}
+	})
+	route := c.Route().Path
+	if c.Response().StatusCode() == fiber.StatusNotFound && !n.endpoints[method+" "+route] {
+		return unmatchedRoute
+	}
+	return route
+}
+
+// RequestMetrics counts each request and times it, labelled with the route
+// pattern that handled it
+func RequestMetrics() fiber.Handler {
+	routes := &routeNamer{}
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		// Handle the error here, like RequestLogger, so the recorded status
+		// is the one sent
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		// The method is copied as Fiber reuses its memory for the next request
+		method := utils.CopyString(c.Method())
+		labels := []string{method, routes.name(c, method), strconv.Itoa(c.Response().StatusCode())}
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
+
+// MetricsHandler serves /metrics for Prometheus to scrape. With a token set,
+// scrapes must send it as a bearer token.
+type MetricsHandler struct {
+	token string
+}
+
+// NewMetricsHandler creates the metrics handler
+func NewMetricsHandler(token string) *MetricsHandler {
+	return &MetricsHandler{token: token}
+}
+
+// Register adds the metrics endpoint
+func (h *MetricsHandler) Register(router fiber.Router) {
+	router.Get("/metrics", h.authorize, adaptor.HTTPHandler(metrics.Handler()))
+}
+
+func (h *MetricsHandler) authorize(c *fiber.Ctx) error {
+	if h.token == "" {
+		return c.Next()
+	}
+	want := "Bearer " + h.token
+	if subtle.ConstantTimeCompare([]byte(c.Get(fiber.HeaderAuthorization)), []byte(want)) != 1 {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid metrics token")
+	}
+	return c.Next()
+}
@@ -0,0 +1,182 @@
+// Package httpapi serves the store's JSON API and storefront pages over
+// Fiber. Handlers get the repositories and services they use when they are
+// created and register their routes on a router.
+package httpapi
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/domain"
+)
+
+// Ways a request can be authenticated
+const (
+	AuthMethodSession = "session"
+	AuthMethodBearer  = "bearer"
+	AuthMethodAPIKey  = "api_key"
+)
+
+// Keys of the request locals set by AuthMiddleware
+const (
+	localUserID     = "userID"
+	localAuthMethod = "authMethod"
+	localAPIKey     = "apiKey"
+	localScopeOK    = "scopeOK"
+	localSessionID  = "sessionID"
+)
+
+// errInvalidAccessToken rejects a bearer access token that is malformed,
+// expired or issued to an account that no longer exists
+var errInvalidAccessToken = domain.Unauthorized("invalid_access_token", "Invalid or expired access token")
+
+// AuthMiddleware resolves the user making a request, from an
+// "Authorization: Bearer" access token or personal API key or else from the
+// session cookie, so handlers find them the same way whichever was used.
+// Requests with a bearer credential that is not valid are rejected rather
+// than treated as anonymous. Access tokens are checked against the users
+// table, so they stop working when their account is deleted.
+func AuthMiddleware(sessions *Sessions, tokens *auth.APITokens, keys domain.APIKeyRepository, users domain.UserRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if header := c.Get(fiber.HeaderAuthorization); header != "" {
+			scheme, credentials, _ := strings.Cut(header, " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				return domain.Unauthorized("unsupported_auth_scheme", "Unsupported authorization scheme")
+			}
+			credentials = strings.TrimSpace(credentials)
+
+			if strings.HasPrefix(credentials, auth.APIKeyPrefix) {
+				key, err := auth.AuthenticateAPIKey(c.UserContext(), keys, credentials)
+				if errors.Is(err, domain.ErrInvalidToken) {
+					c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+					return domain.Unauthorized("invalid_api_key", "Invalid, expired or revoked API key")
+				}
+				if err != nil {
+					return err
+				}
+				c.Locals(localUserID, key.UserID)
+				c.Locals(localAuthMethod, AuthMethodAPIKey)
+				c.Locals(localAPIKey, key)
+				return c.Next()
+			}
+
+			userID, err := tokens.Verify(credentials)
+			if err != nil {
+				c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return errInvalidAccessToken
+			}
+			user, err := users.GetByID(c.UserContext(), userID)
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return errInvalidAccessToken
+			}
+			c.Locals(localUserID, userID)
+			c.Locals(localAuthMethod, AuthMethodBearer)
+			return c.Next()
+		}
+
+		userID, sessionID, err := sessions.Authenticate(c)
+		if err != nil {
+			return err
+		}
+		if userID != 0 {
+			c.Locals(localUserID, userID)
+			c.Locals(localAuthMethod, AuthMethodSession)
+			c.Locals(localSessionID, sessionID)
+		}
+		return c.Next()
+	}
+}
+
+// RequireScope lets requests made with an API key through only if the key
+// has scope. Sessions and access tokens act with the user's full rights and
+// are not affected. Routes without RequireScope do not accept API keys:
+// currentUserID treats their requests as anonymous.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if key, ok := c.Locals(localAPIKey).(*domain.APIKey); ok {
+			if !key.HasScope(scope) {
+				c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				return domain.Forbidden("insufficient_scope", "API key lacks the "+scope+" scope")
+			}
+			c.Locals(localScopeOK, true)
+		}
+		return c.Next()
+	}
+}
+
+// currentUserID returns the user AuthMiddleware found for the request, if any
+func currentUserID(c *fiber.Ctx) (int, bool) {
+	if _, ok := c.Locals(localAPIKey).(*domain.APIKey); ok {
+		if granted, _ := c.Locals(localScopeOK).(bool); !granted {
+			return 0, false
+		}
+	}
+	userID, ok := c.Locals(localUserID).(int)
+	return userID, ok
+}
+
+// currentAuthMethod returns how the request was authenticated, or "" if it was not
+func currentAuthMethod(c *fiber.Ctx) string {
+	method, _ := c.Locals(localAuthMethod).(string)
+	return method
+}
+
+// currentSessionID returns the ID of the cookie session the request was
+// authenticated with, or "" if it was not
+func currentSessionID(c *fiber.Ctx) string {
+	id, _ := c.Locals(localSessionID).(string)
+	return id
+}
+
+// currentUser loads the user making the request, rejecting anonymous requests
+func currentUser(c *fiber.Ctx, users domain.UserRepository) (*domain.User, error) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, errNotLoggedIn
+	}
+	user, err := users.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errNotLoggedIn
+	}
+	return user, nil
+}
+
+// requireStaff loads the user making the request, rejecting anyone who is
+// not staff
+func requireStaff(c *fiber.Ctx, users domain.UserRepository, twoFactor domain.TwoFactorRepository) (*domain.User, error) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, errNotLoggedIn
+	}
+
+	user, err := users.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.IsStaff() {
+		return nil, domain.Forbidden("staff_only", "Staff only")
+	}
+
+	// Covers sessions from before the user became an admin
+	if user.RequiresTwoFactor() {
+		enabled, err := auth.TwoFactorEnabled(c.UserContext(), twoFactor, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !enabled {
+			return nil, auth.ErrTwoFactorMandatory
+		}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/storage"
+)
+
+func TestAccessTokenOfDeletedAccount(t *testing.T) {
+	ctx := context.Background()
+	db, err := storage.Open(storage.DialectSQLite, filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	users := storage.NewUserRepository(db)
+	tokens, err := auth.NewAPITokens(ctx, storage.NewSecretRepository(db), storage.NewRefreshTokenRepository(db))
+	if err != nil {
+		t.Fatalf("creating token issuer: %v", err)
+	}
+	sessionStorage := storage.NewSessionStorage(db, time.Hour)
+	t.Cleanup(func() { sessionStorage.Close() })
+	sessions := NewSessions(storage.NewSessionRepository(db), session.New(session.Config{Storage: sessionStorage}), DefaultSessionConfig())
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(AuthMiddleware(sessions, tokens, storage.NewAPIKeyRepository(db), users))
+	NewProfileHandler(storage.NewProfileRepository(db)).Register(app)
+
+	alice, err := users.Create(ctx, "alice", "unused")
+	if err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	pair, err := tokens.Issue(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("issuing tokens: %v", err)
+	}
+
+	getProfile := func() (int, string) {
+		req := httptest.NewRequest(fiber.MethodGet, "/profile", nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+pair.AccessToken)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("GET /profile: %v", err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode, readBody(t, resp)
+	}
+
+	if status, body := getProfile(); status != fiber.StatusOK {
+		t.Fatalf("before deleting: status %d, body %s", status, body)
+	}
+
+	if err := storage.NewAccountRepository(db).Delete(ctx, alice.ID); err != nil {
+		t.Fatalf("deleting account: %v", err)
+	}
+
+	// The token has not expired, but its account is gone
+	status, body := getProfile()
+	if status != fiber.StatusUnauthorized || !strings.Contains(body, "invalid_access_token") {
+		t.Errorf("after deleting: status %d, body %s; want 401 invalid_access_token", status, body)
+	}
+}
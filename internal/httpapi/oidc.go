@@ -0,0 +1,151 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/domain"
+)
+
+// registerOIDC adds the OpenID Connect login endpoints. The browser is sent
+// to the provider by /api/oidc/<name>/login and comes back to the callback,
+// which logs it in and redirects to the storefront. Logged-in users who go
+// through the flow link the provider to their account instead.
+func (h *AuthHandler) registerOIDC(router fiber.Router) {
+	router.Get("/oidc/providers", h.oidcProviders)
+	router.Get("/oidc/:provider/login", h.oidcLogin)
+	router.Get("/oidc/:provider/callback", h.oidcCallback)
+
+	router.Get("/identities", h.listIdentities)
+	router.Delete("/identities/:provider", h.unlinkIdentity)
+}
+
+// oidcCallbackURL is where a provider sends the browser back to. It is
+// built from the configured public address, which is what providers have
+// registered, not from the request's Host header.
+func (h *AuthHandler) oidcCallbackURL(provider string) string {
+	return h.PublicURL + "/api/oidc/" + url.PathEscape(provider) + "/callback"
+}
+
+func (h *AuthHandler) oidcProviders(c *fiber.Ctx) error {
+	return c.JSON(h.OIDC.Providers())
+}
+
+func (h *AuthHandler) oidcLogin(c *fiber.Ctx) error {
+	provider := c.Params("provider")
+	authURL, req, err := h.OIDC.Begin(provider, h.oidcCallbackURL(provider))
+	if errors.Is(err, auth.ErrUnknownProvider) {
+		return err
+	}
+	if err != nil {
+		// The provider could not be reached; the error is logged, not sent
+		return fiber.NewError(fiber.StatusBadGateway, err.Error())
+	}
+
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	sess, err := h.Sessions.Get(c)
+	if err != nil {
+		return err
+	}
+	sess.Set("oidcRequest", string(encoded))
+	if err := sess.Save(); err != nil {
+		return err
+	}
+
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+func (h *AuthHandler) oidcCallback(c *fiber.Ctx) error {
+	provider := c.Params("provider")
+	fail := func(message string) error {
+		return c.Redirect("/?loginError="+url.QueryEscape(message), fiber.StatusFound)
+	}
+
+	sess, err := h.Sessions.Get(c)
+	if err != nil {
+		return err
+	}
+	var req *auth.OIDCRequest
+	if encoded, ok := sess.Get("oidcRequest").(string); ok {
+		json.Unmarshal([]byte(encoded), &req)
+	}
+	sess.Delete("oidcRequest")
+	if err := sess.Save(); err != nil {
+		return err
+	}
+
+	if msg := c.Query("error"); msg != "" {
+		return fail("The login provider reported: " + msg)
+	}
+
+	claims, err := h.OIDC.Finish(c.UserContext(), req, provider, c.Query("state"), c.Query("code"), h.oidcCallbackURL(provider))
+	if errors.Is(err, auth.ErrInvalidOIDCState) || errors.Is(err, auth.ErrUnknownProvider) {
+		return fail(err.Error())
+	}
+	if err != nil {
+		slog.WarnContext(c.UserContext(), "completing OpenID Connect login", "provider", provider, "error", err)
+		return fail("Login with the provider failed")
+	}
+
+	// Only a cookie session links; the flow runs in a browser
+	loggedInUserID := 0
+	if currentAuthMethod(c) == AuthMethodSession {
+		loggedInUserID, _ = currentUserID(c)
+	}
+
+	user, _, err := h.OIDC.ResolveIdentity(c.UserContext(), h.Users, h.Identities, provider, claims, loggedInUserID)
+	if errors.Is(err, domain.ErrIdentityInUse) {
+		return fail(err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	if loggedInUserID != 0 {
+		return c.Redirect("/?linked="+url.QueryEscape(provider), fiber.StatusFound)
+	}
+
+	outcome, err := h.startSession(c, user)
+	if err != nil {
+		return err
+	}
+	if outcome != loginComplete {
+		return c.Redirect("/?twoFactor="+outcome, fiber.StatusFound)
+	}
+	return c.Redirect("/", fiber.StatusFound)
+}
+
+func (h *AuthHandler) listIdentities(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	identities, err := h.Identities.ListByUser(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(identities)
+}
+
+func (h *AuthHandler) unlinkIdentity(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	err := h.Identities.Unlink(c.UserContext(), userID, c.Params("provider"))
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
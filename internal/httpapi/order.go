@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/domain"
+	"go-commerce/internal/metrics"
+)
+
+// OrderHandler serves a user's orders
+type OrderHandler struct {
+	orders domain.OrderRepository
+}
+
+// NewOrderHandler creates the order handler
+func NewOrderHandler(orders domain.OrderRepository) *OrderHandler {
+	return &OrderHandler{orders: orders}
+}
+
+// Register adds the order routes
+func (h *OrderHandler) Register(router fiber.Router) {
+	router.Post("/orders", RequireScope(domain.ScopeWriteOrders), h.create)
+	router.Get("/orders", RequireScope(domain.ScopeReadOrders), h.list)
+}
+
+type createOrderRequest struct {
+	CartID int `json:"cartId"`
+}
+
+func (h *OrderHandler) create(c *fiber.Ctx) error {
+	slog.DebugContext(c.UserContext(), "received request to create order")
+	userID, ok := currentUserID(c)
+	if !ok {
+		slog.DebugContext(c.UserContext(), "user not logged in")
+		return errNotLoggedIn
+	}
+
+	var req createOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		slog.DebugContext(c.UserContext(), "parsing request body", "error", err)
+		return errInvalidBody
+	}
+
+	order, err := h.orders.CreateFromCart(c.UserContext(), req.CartID, userID)
+	if errors.Is(err, domain.ErrEmptyCart) {
+		slog.InfoContext(c.UserContext(), "cannot create an empty order", "cart_id", req.CartID)
+		return err
+	}
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "creating order", "cart_id", req.CartID, "error", err)
+		return err
+	}
+
+	metrics.OrdersPlaced.Inc()
+	metrics.OrderValue.Observe(order.Total())
+
+	slog.DebugContext(c.UserContext(), "order created", "order_id", order.ID)
+	return c.JSON(order)
+}
+
+func (h *OrderHandler) list(c *fiber.Ctx) error {
+	slog.DebugContext(c.UserContext(), "received request to get orders")
+	userID, ok := currentUserID(c)
+	if !ok {
+		slog.DebugContext(c.UserContext(), "user not logged in")
+		return errNotLoggedIn
+	}
+
+	orders, err := h.orders.ListByUser(c.UserContext(), userID)
+	if err != nil {
+		slog.ErrorContext(c.UserContext(), "getting orders", "user_id", userID, "error", err)
+		return err
+	}
+
+	slog.DebugContext(c.UserContext(), "returning orders", "user_id", userID, "orders", len(orders))
+	return c.JSON(orders)
+}
@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/domain"
+)
+
+// ProfileHandler lets users see and edit their profile
+type ProfileHandler struct {
+	profiles domain.ProfileRepository
+}
+
+// NewProfileHandler creates the profile handler
+func NewProfileHandler(profiles domain.ProfileRepository) *ProfileHandler {
+	return &ProfileHandler{profiles: profiles}
+}
+
+// Register adds the profile routes
+func (h *ProfileHandler) Register(router fiber.Router) {
+	router.Get("/profile", RequireScope(domain.ScopeReadProfile), h.get)
+	router.Put("/profile", h.update)
+}
+
+func (h *ProfileHandler) get(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	profile, err := h.profiles.Get(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		return domain.ErrUserNotFound
+	}
+
+	return c.JSON(profile)
+}
+
+func (h *ProfileHandler) update(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	var req domain.ProfileUpdate
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	profile, err := h.profiles.Update(c.UserContext(), userID, req)
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		return domain.ErrUserNotFound
+	}
+
+	return c.JSON(profile)
+}
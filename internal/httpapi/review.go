@@ -0,0 +1,283 @@
+package httpapi
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/domain"
+	"go-commerce/internal/media"
+)
+
+// Photo upload errors. Oversized photos keep their own status, 413.
+var (
+	errInvalidPhoto     = domain.Invalid("photo", "invalid_photo", "Invalid photo")
+	errUnsupportedPhoto = domain.Invalid("photo", "unsupported_photo", media.ErrUnsupported.Error())
+	errPhotoTooLarge    = fiber.NewError(fiber.StatusRequestEntityTooLarge, media.ErrTooLarge.Error())
+)
+
+// ReviewHandler serves product reviews and their moderation
+type ReviewHandler struct {
+	reviews   domain.ReviewRepository
+	products  domain.ProductRepository
+	users     domain.UserRepository
+	twoFactor domain.TwoFactorRepository
+	media     domain.MediaStorage
+}
+
+// NewReviewHandler creates the review handler. Review photos are kept in
+// mediaStore.
+func NewReviewHandler(reviews domain.ReviewRepository, products domain.ProductRepository, users domain.UserRepository, twoFactor domain.TwoFactorRepository, mediaStore domain.MediaStorage) *ReviewHandler {
+	return &ReviewHandler{reviews: reviews, products: products, users: users, twoFactor: twoFactor, media: mediaStore}
+}
+
+// Register adds the review routes
+func (h *ReviewHandler) Register(router fiber.Router) {
+	// Reviews endpoints
+	router.Get("/products/:id/reviews", RequireScope(domain.ScopeReadCatalog), h.listProductReviews)
+	router.Post("/products/:id/reviews", RequireScope(domain.ScopeWriteReviews), h.createReview)
+	router.Post("/reviews/:id/vote", RequireScope(domain.ScopeWriteReviews), h.vote)
+	router.Post("/reviews/:id/media", RequireScope(domain.ScopeWriteReviews), h.addMedia)
+
+	// Review moderation endpoints
+	router.Get("/admin/reviews", RequireScope(domain.ScopeAdminReviews), h.listForModeration)
+	router.Post("/admin/reviews/:id/moderate", RequireScope(domain.ScopeAdminReviews), h.moderate)
+	router.Put("/admin/reviews/:id/reply", RequireScope(domain.ScopeAdminReviews), h.reply)
+}
+
+// RegisterPhotos adds the route review photos are served from. router must
+// be mounted at the URL prefix of the media storage, with AuthMiddleware.
+func (h *ReviewHandler) RegisterPhotos(router fiber.Router) {
+	router.Get("/reviews/:name", RequireScope(domain.ScopeReadCatalog), h.servePhoto)
+}
+
+// servePhoto serves a review photo to everyone once the review is approved.
+// Until then only its author and staff can see it, so photos awaiting
+// moderation or rejected are not published.
+func (h *ReviewHandler) servePhoto(c *fiber.Ctx) error {
+	review, err := h.reviews.GetByMediaURL(c.UserContext(), c.Path())
+	if errors.Is(err, domain.ErrReviewNotFound) {
+		return fiber.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if review.Status != domain.ReviewStatusApproved {
+		visible := false
+		if userID, ok := currentUserID(c); ok {
+			user, err := h.users.GetByID(c.UserContext(), userID)
+			if err != nil {
+				return err
+			}
+			visible = user != nil && (user.ID == review.UserID || user.IsStaff())
+		}
+		if !visible {
+			return fiber.ErrNotFound
+		}
+		c.Set(fiber.HeaderCacheControl, "private, no-store")
+	}
+
+	path, err := h.media.Path(c.Path())
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+	return c.SendFile(path)
+}
+
+func (h *ReviewHandler) listProductReviews(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("product")
+	}
+
+	reviews, err := h.reviews.ListByProduct(c.UserContext(), id, c.Query("sort"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(domain.PublicReviews(reviews))
+}
+
+type createReviewRequest struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+func (h *ReviewHandler) createReview(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("product")
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	var req createReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	// Reviews are only taken for products that exist
+	if _, err := h.products.Get(c.UserContext(), id); err != nil {
+		return err
+	}
+
+	review, created, err := h.reviews.Submit(c.UserContext(), id, userID, req.Rating, req.Comment)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		c.Status(fiber.StatusCreated)
+	}
+	return c.JSON(review.Public())
+}
+
+// voteReviewRequest is a vote on a review. Helpful is required; a missing
+// field is not taken as an unhelpful vote.
+type voteReviewRequest struct {
+	Helpful *bool `json:"helpful"`
+}
+
+func (h *ReviewHandler) vote(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("review")
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	var req voteReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+	if req.Helpful == nil {
+		return domain.Invalid("helpful", "missing_vote", "Say whether the review was helpful")
+	}
+
+	review, err := h.reviews.Vote(c.UserContext(), id, userID, *req.Helpful)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(review.Public())
+}
+
+func (h *ReviewHandler) addMedia(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("review")
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		return errNotLoggedIn
+	}
+
+	fileHeader, err := c.FormFile("photo")
+	if err != nil {
+		return domain.Invalid("photo", "missing_photo", "Missing photo")
+	}
+	if fileHeader.Size > media.MaxSize {
+		return errPhotoTooLarge
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return errInvalidPhoto
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, media.MaxSize+1))
+	if err != nil {
+		return errInvalidPhoto
+	}
+
+	review, err := domain.AddReviewMedia(c.UserContext(), h.reviews, h.media, id, userID, data)
+	switch {
+	case errors.Is(err, media.ErrUnsupported):
+		return errUnsupportedPhoto
+	case errors.Is(err, media.ErrTooLarge):
+		return errPhotoTooLarge
+	case err != nil:
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(review.Public())
+}
+
+func (h *ReviewHandler) listForModeration(c *fiber.Ctx) error {
+	if _, err := requireStaff(c, h.users, h.twoFactor); err != nil {
+		return err
+	}
+
+	reviews, err := h.reviews.ListByStatus(c.UserContext(), c.Query("status", domain.ReviewStatusPending))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(reviews)
+}
+
+type moderateReviewRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+func (h *ReviewHandler) moderate(c *fiber.Ctx) error {
+	moderator, err := requireStaff(c, h.users, h.twoFactor)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("review")
+	}
+
+	var req moderateReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	review, err := h.reviews.Moderate(c.UserContext(), id, moderator.ID, req.Status, req.Note)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(review)
+}
+
+type replyToReviewRequest struct {
+	Reply string `json:"reply"`
+}
+
+func (h *ReviewHandler) reply(c *fiber.Ctx) error {
+	staff, err := requireStaff(c, h.users, h.twoFactor)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return invalidID("review")
+	}
+
+	var req replyToReviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	review, err := h.reviews.Reply(c.UserContext(), id, staff.ID, req.Reply)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(review)
+}
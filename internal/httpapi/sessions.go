@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+
+	"go-commerce/internal/domain"
+)
+
+// sessionSeenResolution is how stale a session's last_seen_at may get, so
+// active sessions do not write to the database on every request. Sliding
+// expiration is extended at the same rate. Short idle timeouts use a tenth
+// of the timeout instead.
+const sessionSeenResolution = time.Minute
+
+// SessionConfig holds the lifetimes of login sessions
+type SessionConfig struct {
+	IdleTimeout time.Duration // A session unused this long expires; each use extends it
+	MaxLifetime time.Duration // A session expires this long after login however much it is used
+}
+
+// DefaultSessionConfig returns the lifetimes used unless configured otherwise
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		IdleTimeout: 24 * time.Hour,
+		MaxLifetime: 7 * 24 * time.Hour,
+	}
+}
+
+// Validate checks that sessions can be used at all
+func (c SessionConfig) Validate() error {
+	if c.IdleTimeout <= 0 || c.MaxLifetime < c.IdleTimeout {
+		return errors.New("idle timeout must be positive and no longer than the maximum lifetime")
+	}
+	return nil
+}
+
+// Sessions keeps track of the login sessions in the cookie session store.
+// The store holds session data keyed by the cookie; the session repository
+// records who each logged-in session belongs to, where it was last used and
+// when it must end, so users can see and revoke their sessions. A session
+// whose record is gone is treated as logged out.
+type Sessions struct {
+	repo  domain.SessionRepository
+	store *session.Store
+	cfg   SessionConfig
+}
+
+// NewSessions creates the session tracker. The store's expiration should
+// be cfg.IdleTimeout.
+func NewSessions(repo domain.SessionRepository, store *session.Store, cfg SessionConfig) *Sessions {
+	return &Sessions{repo: repo, store: store, cfg: cfg}
+}
+
+// hashSessionID hashes a session cookie value for the session records, so
+// they do not hold live credentials
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the data of the request's session, logged in or not
+func (s *Sessions) Get(c *fiber.Ctx) (*session.Session, error) {
+	return s.store.Get(c)
+}
+
+// Start logs a user into a session and saves it. The session gets a new ID,
+// so an ID planted in the browser before login is useless afterwards.
+func (s *Sessions) Start(c *fiber.Ctx, sess *session.Session, userID int) error {
+	oldID := sess.ID()
+	if err := sess.Regenerate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	record := &domain.UserSession{
+		UserID:     userID,
+		IDHash:     hashSessionID(sess.ID()),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.cfg.MaxLifetime),
+		IP:         c.IP(),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+	}
+	if err := s.repo.Start(c.UserContext(), record, hashSessionID(oldID), now.Add(-s.cfg.IdleTimeout)); err != nil {
+		return err
+	}
+
+	sess.Set("userID", userID)
+	sess.SetExpiry(s.cfg.IdleTimeout)
+	return sess.Save()
+}
+
+// Authenticate returns the user logged into the request's session, or 0 if
+// there is none, together with the session ID. Sessions that were revoked
+// or have expired are destroyed. Otherwise the session's last use is
+// recorded and its expiry pushed back, up to its maximum lifetime.
+func (s *Sessions) Authenticate(c *fiber.Ctx) (int, string, error) {
+	sess, err := s.store.Get(c)
+	if err != nil {
+		return 0, "", err
+	}
+	userID, ok := sess.Get("userID").(int)
+	if !ok {
+		return 0, "", nil
+	}
+	id := sess.ID()
+
+	record, err := s.repo.Get(c.UserContext(), hashSessionID(id), userID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	now := time.Now()
+	if record == nil || !now.Before(record.ExpiresAt) || now.Sub(record.LastSeenAt) >= s.cfg.IdleTimeout {
+		if err := s.repo.DeleteByHash(c.UserContext(), hashSessionID(id)); err != nil {
+			return 0, "", err
+		}
+		return 0, "", sess.Destroy()
+	}
+
+	if now.Sub(record.LastSeenAt) >= min(sessionSeenResolution, s.cfg.IdleTimeout/10) {
+		if err := s.repo.Touch(c.UserContext(), record.ID, now, c.IP(), c.Get(fiber.HeaderUserAgent)); err != nil {
+			return 0, "", err
+		}
+		ttl := s.cfg.IdleTimeout
+		if remaining := record.ExpiresAt.Sub(now); remaining < ttl {
+			ttl = remaining
+		}
+		sess.SetExpiry(ttl)
+		if err := sess.Save(); err != nil {
+			return 0, "", err
+		}
+	}
+
+	return userID, id, nil
+}
+
+// Logout ends the request's session
+func (s *Sessions) Logout(c *fiber.Ctx) error {
+	sess, err := s.store.Get(c)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteByHash(c.UserContext(), hashSessionID(sess.ID())); err != nil {
+		return err
+	}
+	return sess.Destroy()
+}
+
+// List returns a user's unexpired sessions, most recently used first.
+// currentID is the session ID of the request, to mark its session.
+func (s *Sessions) List(c *fiber.Ctx, userID int, currentID string) ([]domain.UserSession, error) {
+	now := time.Now()
+	sessions, err := s.repo.ListActive(c.UserContext(), userID, now, now.Add(-s.cfg.IdleTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	if currentID != "" {
+		currentHash := hashSessionID(currentID)
+		for i := range sessions {
+			sessions[i].Current = sessions[i].IDHash == currentHash
+		}
+	}
+
+	return sessions, nil
+}
+
+// Revoke ends one of a user's sessions
+func (s *Sessions) Revoke(c *fiber.Ctx, userID, sessionID int) error {
+	return s.repo.Delete(c.UserContext(), sessionID, userID)
+}
+
+// RevokeAllForUser ends every session of a user except the one with ID
+// exceptID, which may be "" to end them all
+func (s *Sessions) RevokeAllForUser(c *fiber.Ctx, userID int, exceptID string) error {
+	exceptHash := ""
+	if exceptID != "" {
+		exceptHash = hashSessionID(exceptID)
+	}
+	return s.repo.DeleteAllForUser(c.UserContext(), userID, exceptHash)
+}
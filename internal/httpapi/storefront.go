@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/xml"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-commerce/internal/domain"
+)
+
+// sitemapURLSet is the root element of a sitemap.xml document
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapURL is a single page entry in a sitemap
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+// StorefrontHandler serves the pages search engines and customers land on
+// outside the API: the sitemap and the product and category pages
+type StorefrontHandler struct {
+	slugs     domain.SlugRepository
+	indexFile string
+	baseURL   string
+}
+
+// NewStorefrontHandler creates the storefront handler. indexFile is the
+// single-page app served for product and category pages; baseURL is the
+// public address the sitemap lists pages under.
+func NewStorefrontHandler(slugs domain.SlugRepository, indexFile, baseURL string) *StorefrontHandler {
+	return &StorefrontHandler{slugs: slugs, indexFile: indexFile, baseURL: baseURL}
+}
+
+// Register adds the storefront routes
+func (h *StorefrontHandler) Register(router fiber.Router) {
+	// SEO endpoints
+	router.Get("/sitemap.xml", h.sitemap)
+
+	// Storefront pages are served by the single-page app; old slugs redirect
+	// permanently to the current ones
+	router.Get("/products/:slug", h.page(domain.SlugEntityProduct, "/products/"))
+	router.Get("/categories/:slug", h.page(domain.SlugEntityCategory, "/categories/"))
+}
+
+func (h *StorefrontHandler) sitemap(c *fiber.Ctx) error {
+	sitemap, err := h.generateSitemap(c.UserContext(), h.baseURL)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+	return c.Send(sitemap)
+}
+
+// generateSitemap builds a sitemap.xml listing every category and every
+// active product under baseURL
+func (h *StorefrontHandler) generateSitemap(ctx context.Context, baseURL string) ([]byte, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	set := sitemapURLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		URLs:  []sitemapURL{{Loc: baseURL + "/"}},
+	}
+
+	sections := []struct {
+		entityType string
+		prefix     string
+	}{
+		{domain.SlugEntityCategory, "/categories/"},
+		{domain.SlugEntityProduct, "/products/"},
+	}
+
+	for _, s := range sections {
+		slugs, err := h.slugs.Slugs(ctx, s.entityType)
+		if err != nil {
+			return nil, err
+		}
+		for _, slug := range slugs {
+			set.URLs = append(set.URLs, sitemapURL{Loc: baseURL + s.prefix + url.PathEscape(slug)})
+		}
+	}
+
+	out, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), out...), nil
+}
+
+func (h *StorefrontHandler) page(entityType, prefix string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		slug, err := url.PathUnescape(c.Params("slug"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid slug")
+		}
+
+		id, current, err := h.slugs.Resolve(c.UserContext(), entityType, slug)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			return fiber.NewError(fiber.StatusNotFound, "Page not found")
+		}
+		if current != slug {
+			return c.Redirect(prefix+url.PathEscape(current), fiber.StatusMovedPermanently)
+		}
+
+		return c.SendFile(h.indexFile)
+	}
+}
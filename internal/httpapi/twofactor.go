@@ -0,0 +1,169 @@
+package httpapi
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/domain"
+)
+
+// registerTwoFactor adds the two-factor enrollment endpoints. Users who must
+// use two-factor authentication but have not enrolled can reach the
+// enrollment endpoints with the partial session from /api/login, which is
+// returned so it can be completed.
+func (h *AuthHandler) registerTwoFactor(router fiber.Router) {
+	router.Get("/2fa", h.twoFactorStatus)
+	router.Post("/2fa/enroll", h.enrollTwoFactor)
+	router.Post("/2fa/confirm", h.confirmTwoFactor)
+	router.Post("/2fa/recovery-codes", h.regenerateRecoveryCodes)
+	router.Post("/2fa/disable", h.disableTwoFactor)
+}
+
+// twoFactorUser loads the user making the request. With allowPending, a
+// partial session is accepted too and returned.
+func (h *AuthHandler) twoFactorUser(c *fiber.Ctx, allowPending bool) (*domain.User, *session.Session, error) {
+	var pendingSess *session.Session
+	userID, ok := currentUserID(c)
+	if !ok && allowPending {
+		sess, err := h.Sessions.Get(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if userID = pendingLogin(sess); userID != 0 {
+			pendingSess = sess
+		}
+	}
+	if userID == 0 {
+		return nil, nil, errNotLoggedIn
+	}
+
+	user, err := h.Users.GetByID(c.UserContext(), userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, errNotLoggedIn
+	}
+
+	return user, pendingSess, nil
+}
+
+func (h *AuthHandler) twoFactorStatus(c *fiber.Ctx) error {
+	user, _, err := h.twoFactorUser(c, false)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := auth.TwoFactorEnabled(c.UserContext(), h.TwoFactor, user.ID)
+	if err != nil {
+		return err
+	}
+	remaining, err := auth.RemainingRecoveryCodes(c.UserContext(), h.TwoFactor, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"enabled": enabled, "required": user.RequiresTwoFactor(), "recoveryCodesRemaining": remaining})
+}
+
+func (h *AuthHandler) enrollTwoFactor(c *fiber.Ctx) error {
+	user, _, err := h.twoFactorUser(c, true)
+	if err != nil {
+		return err
+	}
+
+	enrollment, err := auth.BeginTOTPEnrollment(c.UserContext(), h.TwoFactor, user.ID, auth.TOTPIssuer)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(enrollment)
+}
+
+func (h *AuthHandler) confirmTwoFactor(c *fiber.Ctx) error {
+	user, pendingSess, err := h.twoFactorUser(c, true)
+	if err != nil {
+		return err
+	}
+
+	var req twoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+
+	codes, err := auth.ConfirmTOTPEnrollment(c.UserContext(), h.TwoFactor, user.ID, req.Code)
+	if err != nil {
+		return err
+	}
+
+	// Confirming enrollment proves the second factor, finishing a pending login
+	if pendingSess != nil {
+		if err := h.completeLogin(c, pendingSess, user.ID); err != nil {
+			return err
+		}
+		h.clearFailures(c, user.Username)
+	}
+
+	return c.JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+// verifyCode checks a code sent to confirm a change to two-factor settings.
+// Wrong codes count towards the login lockout, as they do at login, so a
+// stolen session cannot be used to guess codes.
+func (h *AuthHandler) verifyCode(c *fiber.Ctx, user *domain.User) error {
+	var req twoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return errInvalidBody
+	}
+	if err := h.checkThrottle(c, user.Username, user.ID); err != nil {
+		return err
+	}
+	err := auth.VerifySecondFactor(c.UserContext(), h.TwoFactor, user.ID, req.Code)
+	if errors.Is(err, auth.ErrInvalidTwoFactorCode) {
+		h.recordFailure(c, user.Username, user.ID, auth.LoginFailureBadTOTP)
+	}
+	if err != nil {
+		return err
+	}
+	h.clearFailures(c, user.Username)
+	return nil
+}
+
+func (h *AuthHandler) regenerateRecoveryCodes(c *fiber.Ctx) error {
+	user, _, err := h.twoFactorUser(c, false)
+	if err != nil {
+		return err
+	}
+
+	if err := h.verifyCode(c, user); err != nil {
+		return err
+	}
+
+	codes, err := auth.RegenerateRecoveryCodes(c.UserContext(), h.TwoFactor, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+func (h *AuthHandler) disableTwoFactor(c *fiber.Ctx) error {
+	user, _, err := h.twoFactorUser(c, false)
+	if err != nil {
+		return err
+	}
+
+	if err := h.verifyCode(c, user); err != nil {
+		return err
+	}
+
+	err = auth.DisableTwoFactor(c.UserContext(), h.TwoFactor, user)
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
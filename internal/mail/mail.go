@@ -0,0 +1,121 @@
+// Package mail sends email to the store's users through an SMTP server or,
+// in development, to standard output or a file.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("go-commerce/internal/mail")
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends email through an SMTP server, such as a local relay or a
+// development catcher like MailHog
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string
+	Username string // Optional; PLAIN auth is used when set
+	Password string
+}
+
+// Send delivers a message through the SMTP server
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) (err error) {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+	_, span := tracer.Start(ctx, "smtp send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.ServerAddress(host)))
+	defer endSpan(span, &err)
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, formatMessage(m.From, msg))
+}
+
+// WriterMailer writes messages to an io.Writer instead of sending them. It is
+// meant for development and tests.
+type WriterMailer struct {
+	From string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewStdoutMailer creates a mailer that prints messages to standard output
+func NewStdoutMailer(from string) *WriterMailer {
+	return &WriterMailer{From: from, w: os.Stdout}
+}
+
+// NewFileMailer creates a mailer that appends messages to a file
+func NewFileMailer(from, path string) (*WriterMailer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterMailer{From: from, w: f}, nil
+}
+
+// Send writes the message followed by a separator line
+func (m *WriterMailer) Send(ctx context.Context, msg Message) (err error) {
+	_, span := tracer.Start(ctx, "mail write")
+	defer endSpan(span, &err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.w.Write(formatMessage(m.From, msg)); err != nil {
+		return err
+	}
+	_, err = io.WriteString(m.w, "\r\n----------------------------------------\r\n")
+	return err
+}
+
+// endSpan ends a span, marking it failed if *err is set
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// formatMessage renders a message in RFC 5322 format
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
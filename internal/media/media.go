@@ -0,0 +1,101 @@
+// Package media stores uploaded images.
+package media
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxSize is the largest image accepted for upload, in bytes
+const MaxSize = 3 << 20
+
+var (
+	ErrTooLarge     = errors.New("image must be at most 3 MB")
+	ErrUnsupported  = errors.New("image must be a JPEG, PNG, GIF or WebP file")
+	ErrNotInStorage = errors.New("media URL does not belong to this storage")
+)
+
+// extensionsByMIME lists the accepted image types and the file extension
+// each is stored with
+var extensionsByMIME = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// LocalStorage keeps review photos on disk below Dir, under URLs starting
+// with URLPrefix. Dir must not be served as it is; the review handler
+// decides who may see each photo. It implements domain.MediaStorage.
+type LocalStorage struct {
+	Dir       string
+	URLPrefix string
+}
+
+// NewLocalStorage creates a storage writing to dir and served at urlPrefix
+func NewLocalStorage(dir, urlPrefix string) *LocalStorage {
+	return &LocalStorage{Dir: dir, URLPrefix: strings.TrimSuffix(urlPrefix, "/")}
+}
+
+// DetectImageType checks that data is a supported image and returns its MIME type
+func DetectImageType(data []byte) (string, error) {
+	if len(data) > MaxSize {
+		return "", ErrTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	if _, ok := extensionsByMIME[contentType]; !ok {
+		return "", ErrUnsupported
+	}
+	return contentType, nil
+}
+
+// Save writes an image under a random name in folder
+func (s *LocalStorage) Save(folder string, data []byte) (string, string, error) {
+	contentType, err := DetectImageType(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", "", err
+	}
+	name := hex.EncodeToString(buf[:]) + extensionsByMIME[contentType]
+
+	dir := filepath.Join(s.Dir, filepath.Clean("/"+folder))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+		return "", "", err
+	}
+
+	return s.URLPrefix + filepath.ToSlash(filepath.Clean("/"+folder)) + "/" + name, contentType, nil
+}
+
+// Path returns the file an image's URL refers to
+func (s *LocalStorage) Path(url string) (string, error) {
+	if !strings.HasPrefix(url, s.URLPrefix+"/") {
+		return "", ErrNotInStorage
+	}
+	rel := filepath.Clean("/" + strings.TrimPrefix(url, s.URLPrefix))
+	return filepath.Join(s.Dir, rel), nil
+}
+
+// Delete removes an image previously returned by Save
+func (s *LocalStorage) Delete(url string) error {
+	path, err := s.Path(url)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
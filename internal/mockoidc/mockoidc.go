@@ -0,0 +1,278 @@
+// Package mockoidc is an OpenID Connect provider for development and tests.
+package mockoidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"html/template"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+
+	"go-commerce/internal/auth"
+)
+
+// ProviderName is the provider name the mock provider is registered under
+const ProviderName = "mock"
+
+// keyID identifies the mock provider's signing key in its JWKS
+const keyID = "mock-oidc"
+
+// authorization is an authorization code waiting to be exchanged
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	name          string
+	expiresAt     time.Time
+}
+
+// Provider is a minimal OpenID Connect provider for development and
+// tests, so the login flow can run without network access. It signs in
+// anyone: the authorization page asks for an email address and name, or
+// takes them from the login_hint parameter without asking. It accepts a
+// single client, requires PKCE with S256 and keeps codes in memory.
+//
+// Never enable it in production.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+// New creates a mock provider. issuer must be the absolute
+// URL the provider's routes are mounted at.
+func New(issuer, clientID, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		codes:        map[string]*authorization{},
+	}, nil
+}
+
+// Config returns the login provider configuration that points at the mock provider
+func (m *Provider) Config() auth.OIDCProviderConfig {
+	return auth.OIDCProviderConfig{
+		Name:         ProviderName,
+		DisplayName:  "Mock provider",
+		Issuer:       m.issuer,
+		ClientID:     m.clientID,
+		ClientSecret: m.clientSecret,
+		Scopes:       []string{"email", "profile"},
+	}
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock login</title></head>
+<body>
+<h1>Mock login</h1>
+<p>This development provider signs in whoever you say you are.</p>
+<form method="post">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<p><label>Email <input name="email" type="email" required></label></p>
+<p><label>Name <input name="name"></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// Register mounts the provider's endpoints on a router at the path of its issuer URL
+func (m *Provider) Register(router fiber.Router) {
+	router.Get("/.well-known/openid-configuration", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"issuer":                                m.issuer,
+			"authorization_endpoint":                m.issuer + "/authorize",
+			"token_endpoint":                        m.issuer + "/token",
+			"jwks_uri":                              m.issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"scopes_supported":                      []string{"openid", "email", "profile"},
+		})
+	})
+
+	router.Get("/jwks", func(c *fiber.Ctx) error {
+		pub := m.key.PublicKey
+		return c.JSON(fiber.Map{"keys": []fiber.Map{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+
+	authorize := func(c *fiber.Ctx) error {
+		// Values are copied because Fiber reuses the request's memory and
+		// they outlive it in m.codes
+		params := map[string]string{}
+		for _, name := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			params[name] = strings.Clone(c.FormValue(name))
+		}
+		if params["client_id"] != m.clientID || params["response_type"] != "code" || params["redirect_uri"] == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Unknown client or unsupported request")
+		}
+		if params["code_challenge"] == "" || params["code_challenge_method"] != "S256" {
+			return fiber.NewError(fiber.StatusBadRequest, "PKCE with S256 is required")
+		}
+
+		email, name := strings.Clone(c.FormValue("email")), strings.Clone(c.FormValue("name"))
+		if email == "" {
+			email = strings.Clone(c.Query("login_hint"))
+		}
+		if email == "" {
+			c.Type("html")
+			return authorizePage.Execute(c, fiber.Map{"Params": params})
+		}
+
+		code, err := randomString(24)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		for unused, authz := range m.codes {
+			if time.Now().After(authz.expiresAt) {
+				delete(m.codes, unused)
+			}
+		}
+		m.codes[code] = &authorization{
+			clientID:      params["client_id"],
+			redirectURI:   params["redirect_uri"],
+			codeChallenge: params["code_challenge"],
+			nonce:         params["nonce"],
+			email:         strings.ToLower(strings.TrimSpace(email)),
+			name:          strings.TrimSpace(name),
+			expiresAt:     time.Now().Add(time.Minute),
+		}
+		m.mu.Unlock()
+
+		redirect, err := url.Parse(params["redirect_uri"])
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid redirect URI")
+		}
+		q := redirect.Query()
+		q.Set("code", code)
+		q.Set("state", params["state"])
+		redirect.RawQuery = q.Encode()
+		return c.Redirect(redirect.String(), fiber.StatusFound)
+	}
+	router.Get("/authorize", authorize)
+	router.Post("/authorize", authorize)
+
+	router.Post("/token", func(c *fiber.Ctx) error {
+		clientID, clientSecret := c.FormValue("client_id"), c.FormValue("client_secret")
+		if user, pass, ok := basicAuth(c); ok {
+			clientID, clientSecret = user, pass
+		}
+		if clientID != m.clientID || clientSecret != m.clientSecret {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid_client"})
+		}
+
+		m.mu.Lock()
+		authz, ok := m.codes[c.FormValue("code")]
+		delete(m.codes, c.FormValue("code"))
+		m.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(c.FormValue("code_verifier")))
+		if !ok || c.FormValue("grant_type") != "authorization_code" || time.Now().After(authz.expiresAt) ||
+			authz.clientID != clientID || authz.redirectURI != c.FormValue("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != authz.codeChallenge {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_grant"})
+		}
+
+		// The same email always gets the same subject
+		subject := sha256.Sum256([]byte(authz.email))
+		now := time.Now()
+		claims := jwt.MapClaims{
+			"iss":                m.issuer,
+			"aud":                m.clientID,
+			"sub":                hex.EncodeToString(subject[:16]),
+			"iat":                now.Unix(),
+			"exp":                now.Add(time.Hour).Unix(),
+			"email":              authz.email,
+			"email_verified":     true,
+			"preferred_username": strings.Split(authz.email, "@")[0],
+		}
+		if authz.nonce != "" {
+			claims["nonce"] = authz.nonce
+		}
+		if authz.name != "" {
+			claims["name"] = authz.name
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = keyID
+		idToken, err := token.SignedString(m.key)
+		if err != nil {
+			return err
+		}
+
+		accessToken, err := randomString(24)
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+}
+
+// basicAuth reads HTTP Basic credentials, which OAuth 2.0 clients use to
+// authenticate to the token endpoint
+func basicAuth(c *fiber.Ctx) (string, string, bool) {
+	scheme, encoded, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+	// Credentials are form-encoded before being put in the header (RFC 6749 section 2.3.1)
+	if u, err := url.QueryUnescape(user); err == nil {
+		user = u
+	}
+	if p, err := url.QueryUnescape(pass); err == nil {
+		pass = p
+	}
+	return user, pass, true
+}
+
+// randomString returns n random bytes, base64url-encoded
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// AccountRepository works on every table holding a user's data
+type AccountRepository struct {
+	db         *DB
+	profiles   *ProfileRepository
+	orders     *OrderRepository
+	reviews    *ReviewRepository
+	identities *IdentityRepository
+	keys       *APIKeyRepository
+}
+
+// NewAccountRepository creates an account repository
+func NewAccountRepository(db *DB) *AccountRepository {
+	return &AccountRepository{
+		db:         db,
+		profiles:   NewProfileRepository(db),
+		orders:     NewOrderRepository(db),
+		reviews:    NewReviewRepository(db),
+		identities: NewIdentityRepository(db),
+		keys:       NewAPIKeyRepository(db),
+	}
+}
+
+// Export collects everything stored about a user
+func (r *AccountRepository) Export(ctx context.Context, userID int) (*domain.PersonalDataExport, error) {
+	profile, err := r.profiles.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, nil // Not found
+	}
+
+	export := &domain.PersonalDataExport{ExportedAt: time.Now(), Profile: profile, EmailAddresses: []domain.ExportedEmail{}}
+
+	err = r.db.QueryRowContext(ctx, "SELECT role, password != '', totp_enabled_at IS NOT NULL FROM users WHERE id = ?", userID).
+		Scan(&export.Role, &export.HasPassword, &export.TwoFactorEnabled)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Email != "" {
+		export.EmailAddresses = append(export.EmailAddresses, domain.ExportedEmail{Address: profile.Email, Verified: profile.EmailVerified})
+	}
+
+	if export.Orders, err = r.orders.ListByUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Orders == nil {
+		export.Orders = []domain.Order{}
+	}
+	if export.Reviews, err = r.reviews.ListByUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.LinkedLogins, err = r.identities.ListByUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.APIKeys, err = r.keys.ListByUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	export.ReviewVotes = []domain.ExportedVote{}
+	rows, err := r.db.QueryContext(ctx, "SELECT review_id, helpful FROM review_votes WHERE user_id = ? ORDER BY review_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var v domain.ExportedVote
+		if err := rows.Scan(&v.ReviewID, &v.Helpful); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.ReviewVotes = append(export.ReviewVotes, v)
+	}
+	rows.Close()
+
+	export.LoginAttempts = []domain.ExportedLogin{}
+	rows, err = r.db.QueryContext(ctx, "SELECT ip, COALESCE(user_agent, ''), reason, created_at FROM login_attempts WHERE user_id = ? ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var l domain.ExportedLogin
+		if err := rows.Scan(&l.IP, &l.UserAgent, &l.Reason, &l.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.LoginAttempts = append(export.LoginAttempts, l)
+	}
+	rows.Close()
+
+	export.EmailTokens = []domain.ExportedToken{}
+	rows, err = r.db.QueryContext(ctx, "SELECT purpose, COALESCE(email, ''), created_at, used_at FROM user_tokens WHERE user_id = ? ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var t domain.ExportedToken
+		var usedAt sql.NullTime
+		if err := rows.Scan(&t.Purpose, &t.Email, &t.CreatedAt, &usedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if usedAt.Valid {
+			t.UsedAt = &usedAt.Time
+		}
+		export.EmailTokens = append(export.EmailTokens, t)
+	}
+	rows.Close()
+
+	return export, nil
+}
+
+// deletedUsername is the username a deleted account is left with. Usernames
+// may not contain "#", so nobody can register the name beforehand and make
+// the deletion fail on the unique index.
+func deletedUsername(userID int) string {
+	return fmt.Sprintf("#deleted-%d", userID)
+}
+
+// Delete erases a user's personal data. The users row is kept, with its
+// username replaced and everything identifying cleared, so the orders and
+// reviews that point at it keep working; reviews then show as anonymous.
+// Credentials, linked logins, keys, tokens and the login audit trail are
+// deleted, which ends refresh token, API key and session access. Access
+// tokens that are still unexpired stop working because user lookups skip
+// deleted accounts.
+func (r *AccountRepository) Delete(ctx context.Context, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var username string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET username = ?, password = '', role = ?, display_name = NULL, avatar_url = NULL,
+			review_privacy = ?, email = NULL, email_verified_at = NULL,
+			totp_secret = NULL, totp_pending_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL,
+			deleted_at = ?
+		WHERE id = ?
+	`, deletedUsername(userID), domain.RoleCustomer, domain.ReviewPrivacyAnonymous, time.Now(), userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	deletes := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM recovery_codes WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM user_tokens WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM refresh_tokens WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM api_keys WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM user_identities WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM user_sessions WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM login_attempts WHERE user_id = ? OR username = ?", []interface{}{userID, domain.NormalizeUsername(username)}},
+		{"DELETE FROM login_throttle WHERE key = ?", []interface{}{"user:" + domain.NormalizeUsername(username)}},
+	}
+	for _, d := range deletes {
+		if _, err := tx.ExecContext(ctx, d.query, d.args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// APIKeyRepository stores personal API keys in the api_keys table
+type APIKeyRepository struct {
+	db *DB
+}
+
+// NewAPIKeyRepository creates an API key repository
+func NewAPIKeyRepository(db *DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// apiKeyColumns is the list of api_keys columns read by scanAPIKey
+const apiKeyColumns = "id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+// scanAPIKey scans a row selected with apiKeyColumns
+func scanAPIKey(scan func(dest ...interface{}) error) (*domain.APIKey, error) {
+	var k domain.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &scopes, &k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
+
+// Create stores a new key under the hash of its secret and returns its ID
+func (r *APIKeyRepository) Create(ctx context.Context, key *domain.APIKey, keyHash string) (int, error) {
+	var expires interface{}
+	if key.ExpiresAt != nil {
+		expires = *key.ExpiresAt
+	}
+	var id int
+	err := r.db.QueryRowContext(ctx, "INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id",
+		key.UserID, key.Name, key.Prefix, keyHash, strings.Join(key.Scopes, " "), key.CreatedAt, expires).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ListByUser lists a user's keys, newest first, including revoked ones
+func (r *APIKeyRepository) ListByUser(ctx context.Context, userID int) ([]domain.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []domain.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetByHash retrieves the key with the given hash
+func (r *APIKeyRepository) GetByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", keyHash)
+	k, err := scanAPIKey(row.Scan)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+// Revoke revokes a key belonging to ownerID, or to anyone if ownerID is 0.
+// Revoking a key again keeps the time it was first revoked.
+func (r *APIKeyRepository) Revoke(ctx context.Context, id, ownerID int, now time.Time) error {
+	query := "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?"
+	args := []interface{}{now, id}
+	if ownerID != 0 {
+		query += " AND user_id = ?"
+		args = append(args, ownerID)
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchLastUsed records when a key was last used
+func (r *APIKeyRepository) TouchLastUsed(ctx context.Context, id int, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, id)
+	return err
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"go-commerce/internal/domain"
+)
+
+// CartRepository stores shopping carts in the carts and cart_items tables
+type CartRepository struct {
+	db *DB
+}
+
+// NewCartRepository creates a cart repository
+func NewCartRepository(db *DB) *CartRepository {
+	return &CartRepository{db: db}
+}
+
+// Create creates an empty cart and returns its ID
+func (r *CartRepository) Create(ctx context.Context) (int, error) {
+	var id int
+	if err := r.db.QueryRowContext(ctx, "INSERT INTO carts DEFAULT VALUES RETURNING id").Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Get retrieves a cart and its items
+func (r *CartRepository) Get(ctx context.Context, id int) (*domain.Cart, error) {
+	cart := &domain.Cart{ID: id}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT ci.id, ci.quantity, p.id, p.name, p.description, p.price, p.image_url
+		FROM cart_items ci
+		JOIN products p ON ci.product_id = p.id
+		WHERE ci.cart_id = ?
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item domain.CartItem
+		if err := rows.Scan(&item.ID, &item.Quantity, &item.Product.ID, &item.Product.Name, &item.Product.Description, &item.Product.Price, &item.Product.ImageURL); err != nil {
+			return nil, err
+		}
+		item.CartID = id
+		item.ProductID = item.Product.ID
+		cart.Items = append(cart.Items, item)
+	}
+
+	return cart, nil
+}
+
+// AddItem adds an item to a cart
+func (r *CartRepository) AddItem(ctx context.Context, cartID, productID, quantity int) error {
+	var exists int
+	err := r.db.QueryRowContext(ctx, "SELECT 1 FROM products WHERE id = ?", productID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return domain.ErrProductNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var existingQuantity int
+	err = r.db.QueryRowContext(ctx, "SELECT quantity FROM cart_items WHERE cart_id = ? AND product_id = ?", cartID, productID).Scan(&existingQuantity)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if err == sql.ErrNoRows {
+		_, err = r.db.ExecContext(ctx, "INSERT INTO cart_items (cart_id, product_id, quantity) VALUES (?, ?, ?)", cartID, productID, quantity)
+	} else {
+		_, err = r.db.ExecContext(ctx, "UPDATE cart_items SET quantity = ? WHERE cart_id = ? AND product_id = ?", existingQuantity+quantity, cartID, productID)
+	}
+
+	return err
+}
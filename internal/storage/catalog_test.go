@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go-commerce/internal/domain"
+)
+
+func TestProductList(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		products := NewProductRepository(db)
+
+		tests := []struct {
+			name       string
+			search     string
+			categoryID string
+			want       []string
+		}{
+			{"search ignores case", "MACBOOK", "", []string{"MacBook Pro"}},
+			{"search covers descriptions", "noise", "", []string{"Sony WH-1000XM5", "Bose QuietComfort Ultra"}},
+			{"category", "", "4", []string{"Go-Commerce T-Shirt", "Fiber T-Shirt"}},
+			{"search within category", "fiber", "4", []string{"Fiber T-Shirt"}},
+			{"category that is not a number", "", "books", nil},
+		}
+		for _, tt := range tests {
+			got, err := products.List(ctx, tt.search, tt.categoryID, domain.ProductSortDefault)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if names := productNames(got); !slices.Equal(names, tt.want) {
+				t.Errorf("%s: got %q, want %q", tt.name, names, tt.want)
+			}
+		}
+
+		// Inactive products are left out
+		if _, err := db.ExecContext(ctx, "UPDATE products SET active = FALSE WHERE id = ?", 1); err != nil {
+			t.Fatal(err)
+		}
+		got, err := products.List(ctx, "macbook", "", domain.ProductSortDefault)
+		if err != nil || len(got) != 0 {
+			t.Errorf("inactive product listed: %q, %v", productNames(got), err)
+		}
+	})
+}
+
+func TestProductGetUnknown(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		if _, err := NewProductRepository(db).Get(context.Background(), 999); err != domain.ErrProductNotFound {
+			t.Errorf("Get(999) error = %v, want ErrProductNotFound", err)
+		}
+		if _, err := NewCategoryRepository(db).Get(context.Background(), 999); err != domain.ErrCategoryNotFound {
+			t.Errorf("category Get(999) error = %v, want ErrCategoryNotFound", err)
+		}
+	})
+}
+
+func TestRenameKeepsOldSlugs(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		products := NewProductRepository(db)
+		slugs := NewSlugRepository(db)
+
+		renamed, err := products.Rename(ctx, 1, "MacBook Pro M4")
+		if err != nil {
+			t.Fatalf("renaming: %v", err)
+		}
+		if renamed.Name != "MacBook Pro M4" || renamed.Slug != "macbook-pro-m4" {
+			t.Errorf("renamed product = %q with slug %q", renamed.Name, renamed.Slug)
+		}
+		assertResolves(t, slugs, domain.SlugEntityProduct, "macbook-pro", 1, "macbook-pro-m4")
+		assertResolves(t, slugs, domain.SlugEntityProduct, "macbook-pro-m4", 1, "macbook-pro-m4")
+
+		// Another product may not take a slug that still redirects
+		other, err := products.Rename(ctx, 2, "MacBook Pro")
+		if err != nil {
+			t.Fatalf("renaming another product: %v", err)
+		}
+		if other.Slug != "macbook-pro-2" {
+			t.Errorf("second product got slug %q, want macbook-pro-2", other.Slug)
+		}
+
+		// Renaming back makes the old slug current again
+		if _, err := products.Rename(ctx, 1, "MacBook Pro"); err != nil {
+			t.Fatalf("renaming back: %v", err)
+		}
+		assertResolves(t, slugs, domain.SlugEntityProduct, "macbook-pro", 1, "macbook-pro")
+		assertResolves(t, slugs, domain.SlugEntityProduct, "macbook-pro-m4", 1, "macbook-pro")
+
+		if _, err := products.Rename(ctx, 999, "Ghost"); err != domain.ErrProductNotFound {
+			t.Errorf("renaming an unknown product: %v, want ErrProductNotFound", err)
+		}
+
+		// Categories keep theirs the same way
+		categories := NewCategoryRepository(db)
+		if _, err := categories.Rename(ctx, 1, "Notebooks"); err != nil {
+			t.Fatalf("renaming category: %v", err)
+		}
+		assertResolves(t, slugs, domain.SlugEntityCategory, "laptops", 1, "notebooks")
+		if _, err := categories.Rename(ctx, 999, "Ghost"); err != domain.ErrCategoryNotFound {
+			t.Errorf("renaming an unknown category: %v, want ErrCategoryNotFound", err)
+		}
+
+		// Unknown slugs and other entity types do not resolve
+		assertResolves(t, slugs, domain.SlugEntityProduct, "laptops", 0, "")
+		assertResolves(t, slugs, domain.SlugEntityCategory, "no-such-category", 0, "")
+	})
+}
+
+func TestSlugsListsStoreEntities(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		if _, err := db.ExecContext(ctx, "UPDATE products SET active = FALSE WHERE id = ?", 2); err != nil {
+			t.Fatal(err)
+		}
+
+		slugs, err := NewSlugRepository(db).Slugs(ctx, domain.SlugEntityProduct)
+		if err != nil {
+			t.Fatalf("listing product slugs: %v", err)
+		}
+		if len(slugs) != 9 || slugs[0] != "macbook-pro" || slugs[1] != "iphone-15-pro" {
+			t.Errorf("product slugs = %q, want the active products in ID order", slugs)
+		}
+		// Nor do their pages resolve
+		assertResolves(t, NewSlugRepository(db), domain.SlugEntityProduct, "dell-xps-15", 0, "")
+
+		slugs, err = NewSlugRepository(db).Slugs(ctx, domain.SlugEntityCategory)
+		if err != nil {
+			t.Fatalf("listing category slugs: %v", err)
+		}
+		want := []string{"laptops", "smartphones", "books", "t-shirts", "headphones"}
+		if !slices.Equal(slugs, want) {
+			t.Errorf("category slugs = %q, want %q", slugs, want)
+		}
+	})
+}
+
+// assertResolves checks what a slug resolves to
+func assertResolves(t *testing.T, slugs *SlugRepository, entityType, slug string, wantID int, wantCurrent string) {
+	t.Helper()
+	id, current, err := slugs.Resolve(context.Background(), entityType, slug)
+	if err != nil {
+		t.Fatalf("resolving %s %q: %v", entityType, slug, err)
+	}
+	if id != wantID || current != wantCurrent {
+		t.Errorf("%s %q resolves to %d, %q; want %d, %q", entityType, slug, id, current, wantID, wantCurrent)
+	}
+}
+
+func productNames(products []domain.Product) []string {
+	var names []string
+	for _, p := range products {
+		names = append(names, p.Name)
+	}
+	return names
+}
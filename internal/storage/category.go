@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"go-commerce/internal/domain"
+)
+
+// CategoryRepository stores product categories in the categories table
+type CategoryRepository struct {
+	db *DB
+}
+
+// NewCategoryRepository creates a category repository
+func NewCategoryRepository(db *DB) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+// List retrieves all categories
+func (r *CategoryRepository) List(ctx context.Context) ([]domain.Category, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, COALESCE(slug, '') FROM categories")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []domain.Category
+	for rows.Next() {
+		var c domain.Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+
+	return categories, nil
+}
+
+// Get retrieves a single category
+func (r *CategoryRepository) Get(ctx context.Context, id int) (*domain.Category, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT id, name, COALESCE(slug, '') FROM categories WHERE id = ?", id)
+
+	var c domain.Category
+	if err := row.Scan(&c.ID, &c.Name, &c.Slug); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrCategoryNotFound
+		}
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Rename changes a category's name and regenerates its slug. The old slug
+// keeps resolving to the category through slug_history.
+func (r *CategoryRepository) Rename(ctx context.Context, id int, name string) (*domain.Category, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE categories SET name = ? WHERE id = ?", name, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return nil, domain.ErrCategoryNotFound
+	}
+
+	if _, err := setSlug(ctx, tx, domain.SlugEntityCategory, id, name); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, id)
+}
@@ -0,0 +1,623 @@
+// Package storage implements the domain repositories on top of SQLite or
+// PostgreSQL. All of the application's SQL lives here.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SchemaVersion is the version of the schema this build creates. Bump it
+// whenever a migration is added.
+const SchemaVersion = 1
+
+// Open opens the database, creates tables that don't exist yet and brings
+// databases created by older versions up to date. dsn is a file path for
+// SQLite and a connection URL for PostgreSQL.
+func Open(dialect Dialect, dsn string) (*DB, error) {
+	driver := "sqlite3"
+	migrate := migrateSQLite
+	if dialect == DialectPostgres {
+		driver = "pgx"
+		migrate = migratePostgres
+	}
+
+	sqlDB, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	if err := migrate(sqlDB); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	db := &DB{DB: sqlDB, dialect: dialect}
+
+	// Give every product and category without a slug one derived from its name
+	if err := backfillSlugs(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Bring stored rating summaries in line with the reviews
+	if err := refreshAllProductRatings(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := recordSchemaVersion(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// recordSchemaVersion notes that the database has been migrated to
+// SchemaVersion. A database already migrated by a newer build keeps its
+// version, which CheckSchema then reports.
+func recordSchemaVersion(ctx context.Context, db *DB) error {
+	version, err := db.StoredSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		_, err = db.ExecContext(ctx, "INSERT INTO schema_version (version) VALUES (?)", SchemaVersion)
+	} else if version < SchemaVersion {
+		_, err = db.ExecContext(ctx, "UPDATE schema_version SET version = ?", SchemaVersion)
+	}
+	return err
+}
+
+// StoredSchemaVersion returns the schema version recorded in the database,
+// or 0 if there is none
+func (db *DB) StoredSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT version FROM schema_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+// CheckSchema reports an error unless the database is reachable and its
+// schema is the one this build expects
+func (db *DB) CheckSchema(ctx context.Context) error {
+	version, err := db.StoredSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version != SchemaVersion {
+		return fmt.Errorf("database schema is version %d, expected %d", version, SchemaVersion)
+	}
+	return nil
+}
+
+// migrateSQLite creates the SQLite tables. Databases made by older versions
+// get the columns added since.
+func migrateSQLite(db *sql.DB) error {
+	// Enable foreign key support
+	_, err := db.Exec("PRAGMA foreign_keys = ON")
+	if err != nil {
+		return err
+	}
+
+	// Create categories table
+	statement, err := db.Prepare(`
+		CREATE TABLE IF NOT EXISTS categories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create products table
+	statement, err = db.Prepare(`
+        CREATE TABLE IF NOT EXISTS products (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,
+            description TEXT,
+            price REAL NOT NULL,
+            image_url TEXT,
+			category_id INTEGER,
+			FOREIGN KEY(category_id) REFERENCES categories(id)
+        )
+    `)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create reviews table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS reviews (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			product_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			rating INTEGER NOT NULL,
+			comment TEXT,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY(product_id) REFERENCES products(id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create carts table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS carts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create cart_items table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS cart_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			cart_id INTEGER NOT NULL,
+			product_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL,
+			FOREIGN KEY(cart_id) REFERENCES carts(id),
+			FOREIGN KEY(product_id) REFERENCES products(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create users table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create orders table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS orders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create order_items table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS order_items (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			order_id INTEGER NOT NULL,
+			product_id INTEGER NOT NULL,
+			quantity INTEGER NOT NULL,
+			price REAL NOT NULL,
+			FOREIGN KEY(order_id) REFERENCES orders(id),
+			FOREIGN KEY(product_id) REFERENCES products(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Add slug columns to catalog tables created before slugs existed
+	if err := addColumnIfNotExists(db, "categories", "slug", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(db, "products", "slug", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(db, "products", "active", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_slug ON categories(slug)")
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_products_slug ON products(slug)")
+
+	// Create slug_history table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS slug_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entity_type TEXT NOT NULL,
+			entity_id INTEGER NOT NULL,
+			slug TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE(entity_type, slug)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Add moderation columns to reviews and roles to users
+	reviewColumns := []struct{ name, definition string }{
+		{"updated_at", "DATETIME"},
+		{"status", "TEXT NOT NULL DEFAULT 'approved'"},
+		{"moderated_by", "INTEGER REFERENCES users(id)"},
+		{"moderated_at", "DATETIME"},
+		{"moderation_note", "TEXT"},
+	}
+	for _, col := range reviewColumns {
+		if err := addColumnIfNotExists(db, "reviews", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	if err := addColumnIfNotExists(db, "users", "role", "TEXT NOT NULL DEFAULT 'customer'"); err != nil {
+		return err
+	}
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_reviews_status ON reviews(status)")
+	// Fails on databases that already hold duplicate reviews; the review
+	// repository enforces one review per user and product either way
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_reviews_product_user ON reviews(product_id, user_id)")
+
+	// Add the rating summary maintained from approved reviews
+	ratingColumns := []struct{ name, definition string }{
+		{"rating_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"rating_average", "REAL NOT NULL DEFAULT 0"},
+		{"rating_1", "INTEGER NOT NULL DEFAULT 0"},
+		{"rating_2", "INTEGER NOT NULL DEFAULT 0"},
+		{"rating_3", "INTEGER NOT NULL DEFAULT 0"},
+		{"rating_4", "INTEGER NOT NULL DEFAULT 0"},
+		{"rating_5", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, col := range ratingColumns {
+		if err := addColumnIfNotExists(db, "products", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_products_rating ON products(rating_average, rating_count)")
+
+	// Add helpfulness counters and merchant replies to reviews
+	reviewEngagementColumns := []struct{ name, definition string }{
+		{"helpful_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"unhelpful_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"reply", "TEXT"},
+		{"replied_by", "INTEGER REFERENCES users(id)"},
+		{"replied_at", "DATETIME"},
+	}
+	for _, col := range reviewEngagementColumns {
+		if err := addColumnIfNotExists(db, "reviews", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Create review_votes table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS review_votes (
+			review_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			helpful INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY(review_id, user_id),
+			FOREIGN KEY(review_id) REFERENCES reviews(id),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create review_media table
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS review_media (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			review_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY(review_id) REFERENCES reviews(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Add public profile fields to users
+	profileColumns := []struct{ name, definition string }{
+		{"display_name", "TEXT"},
+		{"avatar_url", "TEXT"},
+		{"review_privacy", "TEXT NOT NULL DEFAULT 'public'"},
+	}
+	for _, col := range profileColumns {
+		if err := addColumnIfNotExists(db, "users", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Usernames are unique regardless of letter case. Creating the index fails
+	// on databases that already hold such duplicates; the user repository
+	// checks either way.
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_nocase ON users(username COLLATE NOCASE)")
+
+	// Add email addresses to users
+	if err := addColumnIfNotExists(db, "users", "email", "TEXT"); err != nil {
+		return err
+	}
+	if err := addColumnIfNotExists(db, "users", "email_verified_at", "DATETIME"); err != nil {
+		return err
+	}
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email COLLATE NOCASE)")
+
+	// Create secrets table for server-generated signing keys
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS secrets (
+			name TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create user_tokens table for email verification and password reset
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS user_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			purpose TEXT NOT NULL,
+			email TEXT,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create login_throttle table holding failed login counters per username and IP
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS login_throttle (
+			key TEXT PRIMARY KEY,
+			failures INTEGER NOT NULL,
+			last_failure_at DATETIME NOT NULL,
+			locked_until DATETIME
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create login_attempts table auditing failed logins
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			user_id INTEGER,
+			ip TEXT NOT NULL,
+			user_agent TEXT,
+			reason TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Add TOTP two-factor authentication to users. totp_pending_secret holds a
+	// secret during enrollment until the user confirms a code from it;
+	// totp_last_step stops a code from being used twice.
+	totpColumns := []struct{ name, definition string }{
+		{"totp_secret", "TEXT"},
+		{"totp_pending_secret", "TEXT"},
+		{"totp_enabled_at", "DATETIME"},
+		{"totp_last_step", "INTEGER"},
+	}
+	for _, col := range totpColumns {
+		if err := addColumnIfNotExists(db, "users", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Create recovery_codes table holding hashed one-time two-factor recovery codes
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			used_at DATETIME,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create refresh_tokens table for bearer token clients. Tokens issued from
+	// one login share a family so a reused token can revoke them all.
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			family TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME,
+			revoked_at DATETIME,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family)")
+
+	// Create api_keys table for personal API keys. Only a hash of each key is
+	// stored; scopes are space-separated.
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			revoked_at DATETIME,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Create user_identities table linking OpenID Connect logins to users
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT,
+			created_at DATETIME NOT NULL,
+			UNIQUE(provider, subject),
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	// Mark deleted accounts. Their row stays, scrubbed of personal data, so
+	// the orders and reviews referring to it keep their foreign keys.
+	if err := addColumnIfNotExists(db, "users", "deleted_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	// Create user_sessions table tracking logged-in cookie sessions. id_hash
+	// is the SHA-256 of the session cookie; expires_at is the absolute limit.
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS user_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			id_hash TEXT NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL,
+			last_seen_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			ip TEXT NOT NULL,
+			user_agent TEXT,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id)")
+
+	// Create sessions table holding the data of cookie sessions for
+	// SessionStorage. The layout is the one gofiber's SQLite storage used, so
+	// existing sessions carry over. e is the expiry in Unix seconds, 0 for none.
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			k VARCHAR(64) PRIMARY KEY NOT NULL DEFAULT '',
+			v BLOB NOT NULL,
+			e BIGINT NOT NULL DEFAULT '0'
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+	db.Exec("CREATE INDEX IF NOT EXISTS e ON sessions (e)")
+
+	// Create schema_version table holding the single row Open records
+	// SchemaVersion in
+	statement, err = db.Prepare(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+
+	return nil
+}
+
+// addColumnIfNotExists adds a column to an existing table, so databases
+// created by older versions pick up new fields
+func addColumnIfNotExists(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// querier runs statements on the database or, when a repository is used from
+// inside another repository's transaction, on that transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	Dialect() Dialect
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"go-commerce/internal/domain"
+)
+
+func TestOpenMigratesNewDatabase(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+
+		if err := db.CheckSchema(ctx); err != nil {
+			t.Errorf("CheckSchema: %v", err)
+		}
+		version, err := db.StoredSchemaVersion(ctx)
+		if err != nil || version != SchemaVersion {
+			t.Errorf("stored schema version = %d, %v; want %d", version, err, SchemaVersion)
+		}
+
+		// The sample catalog comes with slugs derived from the names
+		products, err := NewProductRepository(db).List(ctx, "", "", domain.ProductSortDefault)
+		if err != nil {
+			t.Fatalf("listing products: %v", err)
+		}
+		if len(products) != 10 {
+			t.Fatalf("got %d sample products, want 10", len(products))
+		}
+		if products[0].Slug != "macbook-pro" {
+			t.Errorf("first product's slug = %q, want macbook-pro", products[0].Slug)
+		}
+		categories, err := NewCategoryRepository(db).List(ctx)
+		if err != nil {
+			t.Fatalf("listing categories: %v", err)
+		}
+		if len(categories) != 5 || categories[3].Slug != "t-shirts" {
+			t.Errorf("got categories %+v, want the 5 sample ones", categories)
+		}
+	})
+}
+
+func TestOpenIsRepeatable(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, dialect Dialect, dsn string) {
+		ctx := context.Background()
+
+		first, err := Open(dialect, dsn)
+		if err != nil {
+			t.Fatalf("first open: %v", err)
+		}
+		user, err := NewUserRepository(first).Create(ctx, "alice", "hash")
+		if err != nil {
+			t.Fatalf("creating user: %v", err)
+		}
+		first.Close()
+
+		db := openTestDB(t, dialect, dsn)
+		if err := db.CheckSchema(ctx); err != nil {
+			t.Errorf("CheckSchema after reopening: %v", err)
+		}
+		got, err := NewUserRepository(db).GetByID(ctx, user.ID)
+		if err != nil || got == nil || got.Username != "alice" {
+			t.Errorf("user after reopening = %+v, %v", got, err)
+		}
+
+		// The sample data is only added to an empty database
+		var products, versions int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM products").Scan(&products); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_version").Scan(&versions); err != nil {
+			t.Fatal(err)
+		}
+		if products != 10 || versions != 1 {
+			t.Errorf("after reopening: %d products and %d schema versions, want 10 and 1", products, versions)
+		}
+	})
+}
+
+func TestCheckSchemaRejectsNewerSchema(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, dialect Dialect, dsn string) {
+		ctx := context.Background()
+
+		first, err := Open(dialect, dsn)
+		if err != nil {
+			t.Fatalf("first open: %v", err)
+		}
+		if _, err := first.ExecContext(ctx, "UPDATE schema_version SET version = ?", SchemaVersion+1); err != nil {
+			t.Fatal(err)
+		}
+		first.Close()
+
+		// An older build leaves the version of a newer one in place
+		db := openTestDB(t, dialect, dsn)
+		if err := db.CheckSchema(ctx); err == nil {
+			t.Error("CheckSchema accepted a newer schema")
+		}
+		version, err := db.StoredSchemaVersion(ctx)
+		if err != nil || version != SchemaVersion+1 {
+			t.Errorf("stored schema version = %d, %v; want %d", version, err, SchemaVersion+1)
+		}
+	})
+}
+
+// firstSQLiteSchema is the layout of the SQLite databases made before
+// migrations were added
+var firstSQLiteSchema = []string{
+	"CREATE TABLE categories (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE)",
+	`CREATE TABLE products (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, description TEXT,
+		price REAL NOT NULL, image_url TEXT, category_id INTEGER, FOREIGN KEY(category_id) REFERENCES categories(id))`,
+	"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT NOT NULL UNIQUE, password TEXT NOT NULL)",
+	`CREATE TABLE reviews (id INTEGER PRIMARY KEY AUTOINCREMENT, product_id INTEGER NOT NULL, user_id INTEGER NOT NULL,
+		rating INTEGER NOT NULL, comment TEXT, created_at DATETIME NOT NULL)`,
+	"INSERT INTO categories (name) VALUES ('Board Games')",
+	"INSERT INTO products (name, description, price, image_url, category_id) VALUES ('Go Board', 'A 19x19 board.', 80, '', 1)",
+	"INSERT INTO users (username, password) VALUES ('Alice', 'hash')",
+	"INSERT INTO reviews (product_id, user_id, rating, comment, created_at) VALUES (1, 1, 4, 'Solid wood', '2024-01-02 03:04:05')",
+}
+
+func TestOpenMigratesFirstSQLiteSchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "store.db")
+
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range firstSQLiteSchema {
+		if _, err := old.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	old.Close()
+
+	db := openTestDB(t, DialectSQLite, path)
+	if err := db.CheckSchema(ctx); err != nil {
+		t.Errorf("CheckSchema: %v", err)
+	}
+
+	// Existing rows get slugs, and existing reviews count as approved
+	product, err := NewProductRepository(db).Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("getting product: %v", err)
+	}
+	if product.Slug != "go-board" || product.Rating.Count != 1 || product.Rating.Average != 4 {
+		t.Errorf("migrated product = %+v", product)
+	}
+	category, err := NewCategoryRepository(db).Get(ctx, 1)
+	if err != nil || category.Slug != "board-games" {
+		t.Errorf("migrated category = %+v, %v", category, err)
+	}
+	review, err := NewReviewRepository(db).Get(ctx, 1)
+	if err != nil || review == nil || review.Status != domain.ReviewStatusApproved {
+		t.Errorf("migrated review = %+v, %v", review, err)
+	}
+
+	// Old users become customers, found in any letter case
+	user, err := NewUserRepository(db).GetByUsername(ctx, "alice")
+	if err != nil || user == nil || user.Role != domain.RoleCustomer {
+		t.Errorf("migrated user = %+v, %v", user, err)
+	}
+	if _, err := NewUserRepository(db).Create(ctx, "ALICE", "hash"); err != domain.ErrUsernameTaken {
+		t.Errorf("registering a migrated username in other case: %v, want ErrUsernameTaken", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{DialectSQLite, "SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = ? AND b = ?"},
+		{DialectPostgres, "SELECT * FROM t WHERE a = ? AND b = ?", "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{DialectPostgres, "SELECT '?' FROM t WHERE a = ?", "SELECT '?' FROM t WHERE a = $1"},
+		{DialectPostgres, "SELECT 'it''s?' || ? FROM t", "SELECT 'it''s?' || $1 FROM t"},
+		{DialectPostgres, "SELECT 1", "SELECT 1"},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.rebind(tt.query); got != tt.want {
+			t.Errorf("%s rebind(%q) = %q, want %q", tt.dialect, tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-commerce/internal/metrics"
+)
+
+var tracer = otel.Tracer("go-commerce/internal/storage")
+
+// Dialect names the SQL database the store runs on
+type Dialect string
+
+// Supported dialects
+const (
+	DialectSQLite   Dialect = "sqlite"
+	DialectPostgres Dialect = "postgres"
+)
+
+// ParseDialect accepts the names DATABASE_DRIVER may be given
+func ParseDialect(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "", "sqlite", "sqlite3":
+		return DialectSQLite, nil
+	case "postgres", "postgresql", "pgx":
+		return DialectPostgres, nil
+	}
+	return "", errors.New("unknown database driver " + strconv.Quote(name))
+}
+
+// DB is the database the repositories share. Queries are written with ?
+// placeholders, which are rewritten to $1, $2, ... on PostgreSQL, and the
+// few statements that differ between the databases ask the dialect for the
+// right form.
+type DB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+// Dialect reports which database db is
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+// ExecContext runs a statement written with ? placeholders
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span, start := db.dialect.startQuery(ctx, query)
+	res, err := db.DB.ExecContext(ctx, db.dialect.rebind(query), args...)
+	observeQuery(ctx, span, "exec", query, start, err)
+	return res, err
+}
+
+// QueryContext runs a query written with ? placeholders
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span, start := db.dialect.startQuery(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, db.dialect.rebind(query), args...)
+	observeQuery(ctx, span, "query", query, start, err)
+	return rows, err
+}
+
+// QueryRowContext runs a query written with ? placeholders that returns
+// at most one row
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span, start := db.dialect.startQuery(ctx, query)
+	row := db.DB.QueryRowContext(ctx, db.dialect.rebind(query), args...)
+	observeQuery(ctx, span, "query_row", query, start, row.Err())
+	return row
+}
+
+// BeginTx starts a transaction whose statements are rewritten like db's.
+// In a traced request the transaction gets a span of its own, which its
+// statements are children of.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	span := trace.SpanFromContext(ctx)
+	if span.SpanContext().IsValid() {
+		ctx, span = tracer.Start(ctx, "transaction", trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(db.dialect.systemName()))
+	}
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.dialect, span: span}, nil
+}
+
+// Tx is a transaction on a DB
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+	span    trace.Span
+}
+
+// Commit commits the transaction
+func (tx *Tx) Commit() error {
+	err := tx.Tx.Commit()
+	endSpan(tx.span, err)
+	return err
+}
+
+// Rollback aborts the transaction
+func (tx *Tx) Rollback() error {
+	err := tx.Tx.Rollback()
+	if err == nil {
+		tx.span.SetStatus(codes.Error, "rolled back")
+	}
+	tx.span.End()
+	return err
+}
+
+// within makes the transaction's span the parent of a statement's
+func (tx *Tx) within(ctx context.Context) context.Context {
+	if !tx.span.SpanContext().IsValid() {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, tx.span)
+}
+
+// Dialect reports which database the transaction runs on
+func (tx *Tx) Dialect() Dialect {
+	return tx.dialect
+}
+
+// ExecContext runs a statement written with ? placeholders
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span, start := tx.dialect.startQuery(tx.within(ctx), query)
+	res, err := tx.Tx.ExecContext(ctx, tx.dialect.rebind(query), args...)
+	observeQuery(ctx, span, "exec", query, start, err)
+	return res, err
+}
+
+// QueryContext runs a query written with ? placeholders
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span, start := tx.dialect.startQuery(tx.within(ctx), query)
+	rows, err := tx.Tx.QueryContext(ctx, tx.dialect.rebind(query), args...)
+	observeQuery(ctx, span, "query", query, start, err)
+	return rows, err
+}
+
+// QueryRowContext runs a query written with ? placeholders that returns
+// at most one row
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span, start := tx.dialect.startQuery(tx.within(ctx), query)
+	row := tx.Tx.QueryRowContext(ctx, tx.dialect.rebind(query), args...)
+	observeQuery(ctx, span, "query_row", query, start, row.Err())
+	return row
+}
+
+// startQuery starts timing a statement and, if ctx belongs to a trace, a
+// span for it. Statements outside traced requests, such as the removal of
+// expired sessions, do not start traces of their own.
+func (d Dialect) startQuery(ctx context.Context, query string) (context.Context, trace.Span, time.Time) {
+	span := trace.SpanFromContext(ctx)
+	if span.SpanContext().IsValid() {
+		operation := strings.ToUpper(strings.SplitN(strings.TrimSpace(query), " ", 2)[0])
+		ctx, span = tracer.Start(ctx, operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			d.systemName(),
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(strings.Join(strings.Fields(query), " ")),
+		))
+	}
+	return ctx, span, time.Now()
+}
+
+// observeQuery ends a statement's span, records how long it took in the
+// query duration metric and logs it at debug level, with the request ID the
+// context carries. Arguments are left out as they may be passwords or
+// tokens.
+func observeQuery(ctx context.Context, span trace.Span, operation, query string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	outcome := "ok"
+	if err != nil && err != sql.ErrNoRows {
+		outcome = "error"
+	}
+	metrics.DBQueryDuration.WithLabelValues(operation, outcome).Observe(elapsed.Seconds())
+	endSpan(span, err)
+
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []interface{}{"sql", strings.Join(strings.Fields(query), " "), "duration_ms", float64(elapsed.Microseconds()) / 1000}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	}
+	slog.DebugContext(ctx, "query", attrs...)
+}
+
+// endSpan ends a span, marking it failed for errors other than no rows
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// systemName identifies the database in spans
+func (d Dialect) systemName() attribute.KeyValue {
+	if d == DialectPostgres {
+		return semconv.DBSystemNamePostgreSQL
+	}
+	return semconv.DBSystemNameSQLite
+}
+
+// rebind rewrites ? placeholders outside string literals to PostgreSQL's
+// numbered ones
+func (d Dialect) rebind(query string) string {
+	if d != DialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'':
+			quoted = !quoted
+		case ch == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// equalFold is a condition matching column against a ? placeholder
+// regardless of letter case
+func (d Dialect) equalFold(column string) string {
+	if d == DialectPostgres {
+		return "LOWER(" + column + ") = LOWER(?)"
+	}
+	return column + " = ? COLLATE NOCASE"
+}
+
+// like is the operator for a case-insensitive LIKE. SQLite's LIKE already
+// ignores case for ASCII letters.
+func (d Dialect) like() string {
+	if d == DialectPostgres {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+// isUniqueViolation reports whether err is a failed UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
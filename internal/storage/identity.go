@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// IdentityRepository stores external logins in the user_identities table
+type IdentityRepository struct {
+	db *DB
+}
+
+// NewIdentityRepository creates an identity repository
+func NewIdentityRepository(db *DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+// ListByUser lists the external logins linked to a user
+func (r *IdentityRepository) ListByUser(ctx context.Context, userID int) ([]domain.Identity, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT provider, subject, COALESCE(email, ''), created_at FROM user_identities WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []domain.Identity{}
+	for rows.Next() {
+		var i domain.Identity
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+
+	return identities, rows.Err()
+}
+
+// FindUser returns the user an external login is linked to, or 0
+func (r *IdentityRepository) FindUser(ctx context.Context, provider, subject string) (int, error) {
+	var userID int
+	err := r.db.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?", provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil // Not linked
+	}
+	return userID, err
+}
+
+// Link links an external login to a user. Linking the same login again is a
+// no-op; a login linked to someone else gives ErrIdentityInUse.
+func (r *IdentityRepository) Link(ctx context.Context, userID int, provider, subject, email string) error {
+	existing, err := r.FindUser(ctx, provider, subject)
+	if err != nil {
+		return err
+	}
+	if existing != 0 {
+		if existing != userID {
+			return domain.ErrIdentityInUse
+		}
+		return nil
+	}
+
+	_, err = r.db.ExecContext(ctx, "INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, provider, subject, email, time.Now())
+	return err
+}
+
+// Unlink removes an external login from a user, as long as they can still
+// log in with a password or another linked login
+func (r *IdentityRepository) Unlink(ctx context.Context, userID int, provider string) error {
+	var hasPassword bool
+	var identities int
+	err := r.db.QueryRowContext(ctx, `
+		SELECT password != '', (SELECT COUNT(*) FROM user_identities WHERE user_id = users.id)
+		FROM users WHERE id = ?
+	`, userID).Scan(&hasPassword, &identities)
+	if err != nil {
+		return err
+	}
+
+	var linked int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_identities WHERE user_id = ? AND provider = ?", userID, provider).Scan(&linked); err != nil {
+		return err
+	}
+	if linked == 0 {
+		return domain.ErrIdentityNotLinked
+	}
+	if !hasPassword && identities <= linked {
+		return domain.ErrLastLoginMethod
+	}
+
+	_, err = r.db.ExecContext(ctx, "DELETE FROM user_identities WHERE user_id = ? AND provider = ?", userID, provider)
+	return err
+}
+
+// CreateUser creates an account for a new external login. The account has
+// no password (an empty hash never matches) until the user resets one.
+func (r *IdentityRepository) CreateUser(ctx context.Context, user domain.ExternalUser) (*domain.User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	username := user.Username
+	for i := 2; ; i++ {
+		var taken int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE "+tx.Dialect().equalFold("username"), username).Scan(&taken); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if taken == 0 {
+			break
+		}
+		username = fmt.Sprintf("%s%d", user.Username, i)
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO users (username, password, display_name) VALUES (?, '', ?) RETURNING id", username, nullIfEmpty(user.DisplayName)).Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Keep a verified address unless another account already uses it
+	if user.VerifiedEmail != "" {
+		var taken int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE "+tx.Dialect().equalFold("email"), user.VerifiedEmail).Scan(&taken); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if taken == 0 {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET email = ?, email_verified_at = ? WHERE id = ?", user.VerifiedEmail, time.Now(), id); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES (?, ?, ?, ?, ?)",
+		id, user.Provider, user.Subject, user.IdentityEmail, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &domain.User{ID: int(id), Username: username, Role: domain.RoleCustomer}, nil
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
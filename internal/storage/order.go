@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// OrderRepository stores orders in the orders and order_items tables
+type OrderRepository struct {
+	db    *DB
+	carts *CartRepository
+}
+
+// NewOrderRepository creates an order repository
+func NewOrderRepository(db *DB) *OrderRepository {
+	return &OrderRepository{db: db, carts: NewCartRepository(db)}
+}
+
+// CreateFromCart creates a new order from a cart
+func (r *OrderRepository) CreateFromCart(ctx context.Context, cartID, userID int) (*domain.Order, error) {
+	slog.DebugContext(ctx, "creating order", "cart_id", cartID, "user_id", userID)
+	cart, err := r.carts.Get(ctx, cartID)
+	if err != nil {
+		slog.ErrorContext(ctx, "getting cart for order", "cart_id", cartID, "error", err)
+		return nil, err
+	}
+
+	if len(cart.Items) == 0 {
+		slog.InfoContext(ctx, "cannot create an empty order", "cart_id", cartID)
+		return nil, domain.ErrEmptyCart
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "beginning order transaction", "error", err)
+		return nil, err
+	}
+
+	// Create the order
+	order := &domain.Order{UserID: userID, CreatedAt: time.Now()}
+	err = tx.QueryRowContext(ctx, "INSERT INTO orders (user_id, created_at) VALUES (?, ?) RETURNING id", userID, order.CreatedAt).Scan(&order.ID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "creating order", "error", err)
+		return nil, err
+	}
+
+	// Create the order items
+	for _, item := range cart.Items {
+		orderItem := domain.OrderItem{
+			OrderID:   order.ID,
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			Price:     item.Product.Price,
+		}
+		err := tx.QueryRowContext(ctx, "INSERT INTO order_items (order_id, product_id, quantity, price) VALUES (?, ?, ?, ?) RETURNING id",
+			orderItem.OrderID, orderItem.ProductID, orderItem.Quantity, orderItem.Price).Scan(&orderItem.ID)
+		if err != nil {
+			tx.Rollback()
+			slog.ErrorContext(ctx, "creating order item", "product_id", item.ProductID, "error", err)
+			return nil, err
+		}
+		order.Items = append(order.Items, orderItem)
+	}
+
+	// Clear the cart
+	_, err = tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id = ?", cartID)
+	if err != nil {
+		tx.Rollback()
+		slog.ErrorContext(ctx, "clearing cart", "cart_id", cartID, "error", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "committing order", "error", err)
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "order created", "order_id", order.ID, "user_id", userID, "items", len(cart.Items))
+	return order, nil
+}
+
+// ListByUser retrieves all orders for a given user
+func (r *OrderRepository) ListByUser(ctx context.Context, userID int) ([]domain.Order, error) {
+	slog.DebugContext(ctx, "listing orders", "user_id", userID)
+	rows, err := r.db.QueryContext(ctx, "SELECT id, created_at FROM orders WHERE user_id = ? ORDER BY created_at DESC", userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "listing orders", "user_id", userID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []domain.Order
+	for rows.Next() {
+		var order domain.Order
+		order.UserID = userID
+		if err := rows.Scan(&order.ID, &order.CreatedAt); err != nil {
+			slog.ErrorContext(ctx, "scanning order", "error", err)
+			return nil, err
+		}
+
+		// Get order items
+		itemRows, err := r.db.QueryContext(ctx, "SELECT id, product_id, quantity, price FROM order_items WHERE order_id = ?", order.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "listing order items", "order_id", order.ID, "error", err)
+			return nil, err
+		}
+
+		for itemRows.Next() {
+			var item domain.OrderItem
+			item.OrderID = order.ID
+			if err := itemRows.Scan(&item.ID, &item.ProductID, &item.Quantity, &item.Price); err != nil {
+				itemRows.Close()
+				slog.ErrorContext(ctx, "scanning order item", "order_id", order.ID, "error", err)
+				return nil, err
+			}
+			order.Items = append(order.Items, item)
+		}
+		itemRows.Close()
+
+		orders = append(orders, order)
+	}
+
+	slog.DebugContext(ctx, "listed orders", "user_id", userID, "orders", len(orders))
+	return orders, nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"go-commerce/internal/domain"
+)
+
+func TestCartAddItem(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		carts := NewCartRepository(db)
+
+		cartID, err := carts.Create(ctx)
+		if err != nil {
+			t.Fatalf("creating cart: %v", err)
+		}
+		// Adding a product again adds to its quantity
+		for _, quantity := range []int{1, 2} {
+			if err := carts.AddItem(ctx, cartID, 3, quantity); err != nil {
+				t.Fatalf("adding item: %v", err)
+			}
+		}
+		if err := carts.AddItem(ctx, cartID, 999, 1); err != domain.ErrProductNotFound {
+			t.Errorf("adding an unknown product: %v, want ErrProductNotFound", err)
+		}
+
+		cart, err := carts.Get(ctx, cartID)
+		if err != nil {
+			t.Fatalf("getting cart: %v", err)
+		}
+		if len(cart.Items) != 1 || cart.Items[0].ProductID != 3 || cart.Items[0].Quantity != 3 || cart.Items[0].Product.Name == "" {
+			t.Errorf("cart items = %+v, want 3 of product 3", cart.Items)
+		}
+	})
+}
+
+func TestOrderCreateFromCart(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		carts := NewCartRepository(db)
+		orders := NewOrderRepository(db)
+		alice := createUser(t, db, "alice")
+
+		cartID, err := carts.Create(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := orders.CreateFromCart(ctx, cartID, alice.ID); err != domain.ErrEmptyCart {
+			t.Errorf("ordering an empty cart: %v, want ErrEmptyCart", err)
+		}
+
+		if err := carts.AddItem(ctx, cartID, 5, 2); err != nil {
+			t.Fatal(err)
+		}
+		product, err := NewProductRepository(db).Get(ctx, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		order, err := orders.CreateFromCart(ctx, cartID, alice.ID)
+		if err != nil {
+			t.Fatalf("ordering: %v", err)
+		}
+		if len(order.Items) != 1 || order.Items[0].Quantity != 2 || order.Items[0].Price != product.Price {
+			t.Errorf("order items = %+v", order.Items)
+		}
+
+		// The cart is emptied, and the order keeps the price it was placed at
+		cart, err := carts.Get(ctx, cartID)
+		if err != nil || len(cart.Items) != 0 {
+			t.Errorf("cart after ordering = %+v, %v", cart, err)
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE products SET price = price * 2 WHERE id = ?", 5); err != nil {
+			t.Fatal(err)
+		}
+		listed, err := orders.ListByUser(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("listing orders: %v", err)
+		}
+		if len(listed) != 1 || listed[0].ID != order.ID || listed[0].Total() != 2*product.Price {
+			t.Errorf("alice's orders = %+v, want the one order at the old price", listed)
+		}
+
+		// Buying a product makes the author's review a verified purchase
+		review, _, err := NewReviewRepository(db).Submit(ctx, 5, alice.ID, 4, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !review.VerifiedPurchase || !review.Author.VerifiedBuyer {
+			t.Errorf("review after buying = %+v, want a verified purchase", review)
+		}
+	})
+}
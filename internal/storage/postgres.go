@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"database/sql"
+)
+
+// postgresSchema creates the PostgreSQL tables. The PostgreSQL backend
+// started out with the current schema, so unlike migrateSQLite there are no
+// older layouts to bring forward; columns added later must come with an
+// ALTER TABLE ... ADD COLUMN IF NOT EXISTS here.
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS categories (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		slug TEXT UNIQUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS products (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT,
+		price DOUBLE PRECISION NOT NULL,
+		image_url TEXT,
+		category_id INTEGER REFERENCES categories(id),
+		slug TEXT UNIQUE,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		rating_count INTEGER NOT NULL DEFAULT 0,
+		rating_average DOUBLE PRECISION NOT NULL DEFAULT 0,
+		rating_1 INTEGER NOT NULL DEFAULT 0,
+		rating_2 INTEGER NOT NULL DEFAULT 0,
+		rating_3 INTEGER NOT NULL DEFAULT 0,
+		rating_4 INTEGER NOT NULL DEFAULT 0,
+		rating_5 INTEGER NOT NULL DEFAULT 0
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_products_rating ON products(rating_average, rating_count)",
+	`CREATE TABLE IF NOT EXISTS slug_history (
+		id SERIAL PRIMARY KEY,
+		entity_type TEXT NOT NULL,
+		entity_id INTEGER NOT NULL,
+		slug TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		UNIQUE(entity_type, slug)
+	)`,
+	`CREATE TABLE IF NOT EXISTS users (
+		id SERIAL PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		password TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT 'customer',
+		display_name TEXT,
+		avatar_url TEXT,
+		review_privacy TEXT NOT NULL DEFAULT 'public',
+		email TEXT,
+		email_verified_at TIMESTAMPTZ,
+		totp_secret TEXT,
+		totp_pending_secret TEXT,
+		totp_enabled_at TIMESTAMPTZ,
+		totp_last_step BIGINT,
+		deleted_at TIMESTAMPTZ
+	)`,
+	// Usernames and email addresses are unique regardless of letter case
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_nocase ON users(LOWER(username))",
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(LOWER(email))",
+	`CREATE TABLE IF NOT EXISTS reviews (
+		id SERIAL PRIMARY KEY,
+		product_id INTEGER NOT NULL REFERENCES products(id),
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		rating INTEGER NOT NULL,
+		comment TEXT,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ,
+		status TEXT NOT NULL DEFAULT 'approved',
+		moderated_by INTEGER REFERENCES users(id),
+		moderated_at TIMESTAMPTZ,
+		moderation_note TEXT,
+		helpful_count INTEGER NOT NULL DEFAULT 0,
+		unhelpful_count INTEGER NOT NULL DEFAULT 0,
+		reply TEXT,
+		replied_by INTEGER REFERENCES users(id),
+		replied_at TIMESTAMPTZ
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_reviews_status ON reviews(status)",
+	"CREATE UNIQUE INDEX IF NOT EXISTS idx_reviews_product_user ON reviews(product_id, user_id)",
+	`CREATE TABLE IF NOT EXISTS review_votes (
+		review_id INTEGER NOT NULL REFERENCES reviews(id),
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		helpful BOOLEAN NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY(review_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS review_media (
+		id SERIAL PRIMARY KEY,
+		review_id INTEGER NOT NULL REFERENCES reviews(id),
+		url TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS carts (
+		id SERIAL PRIMARY KEY
+	)`,
+	`CREATE TABLE IF NOT EXISTS cart_items (
+		id SERIAL PRIMARY KEY,
+		cart_id INTEGER NOT NULL REFERENCES carts(id),
+		product_id INTEGER NOT NULL REFERENCES products(id),
+		quantity INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS orders (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		created_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS order_items (
+		id SERIAL PRIMARY KEY,
+		order_id INTEGER NOT NULL REFERENCES orders(id),
+		product_id INTEGER NOT NULL REFERENCES products(id),
+		quantity INTEGER NOT NULL,
+		price DOUBLE PRECISION NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS secrets (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		purpose TEXT NOT NULL,
+		email TEXT,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		used_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS login_throttle (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		last_failure_at TIMESTAMPTZ NOT NULL,
+		locked_until TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS login_attempts (
+		id SERIAL PRIMARY KEY,
+		username TEXT NOT NULL,
+		user_id INTEGER REFERENCES users(id),
+		ip TEXT NOT NULL,
+		user_agent TEXT,
+		reason TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		code_hash TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		used_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		family TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		used_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family)",
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ,
+		last_used_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_identities (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		email TEXT,
+		created_at TIMESTAMPTZ NOT NULL,
+		UNIQUE(provider, subject)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_sessions (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		id_hash TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL,
+		last_seen_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		ip TEXT NOT NULL,
+		user_agent TEXT
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id)",
+	// Session data for SessionStorage; e is the expiry in Unix seconds, 0 for none
+	`CREATE TABLE IF NOT EXISTS sessions (
+		k VARCHAR(64) PRIMARY KEY,
+		v BYTEA NOT NULL,
+		e BIGINT NOT NULL DEFAULT 0
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_sessions_e ON sessions(e)",
+	// One row holding the SchemaVersion Open migrated to
+	`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER NOT NULL
+	)`,
+}
+
+// migratePostgres creates the PostgreSQL tables that don't exist yet
+func migratePostgres(db *sql.DB) error {
+	for _, statement := range postgresSchema {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
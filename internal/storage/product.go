@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"go-commerce/internal/domain"
+)
+
+// ProductRepository stores products in the products table
+type ProductRepository struct {
+	db *DB
+}
+
+// NewProductRepository creates a product repository
+func NewProductRepository(db *DB) *ProductRepository {
+	return &ProductRepository{db: db}
+}
+
+// productColumns is the column list shared by product queries
+const productColumns = `
+	id, name, description, price, image_url, category_id, COALESCE(slug, ''),
+	rating_count, rating_1, rating_2, rating_3, rating_4, rating_5
+`
+
+// scanProduct scans a row selected with productColumns
+func scanProduct(scan func(dest ...interface{}) error) (*domain.Product, error) {
+	var p domain.Product
+	var histogram [5]int
+	if err := scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.ImageURL, &p.CategoryID, &p.Slug,
+		&p.Rating.Count, &histogram[0], &histogram[1], &histogram[2], &histogram[3], &histogram[4]); err != nil {
+		return nil, err
+	}
+	p.Rating = domain.NewProductRating(histogram)
+	return &p, nil
+}
+
+// List retrieves the active products. With sortBy set to
+// domain.ProductSortRating the best rated products come first.
+func (r *ProductRepository) List(ctx context.Context, searchTerm, categoryID, sortBy string) ([]domain.Product, error) {
+	query := "SELECT " + productColumns + " FROM products"
+	var args []interface{}
+	whereClauses := []string{"active = TRUE"}
+
+	if searchTerm != "" {
+		like := r.db.Dialect().like()
+		whereClauses = append(whereClauses, "(name "+like+" ? OR description "+like+" ?)")
+		args = append(args, "%"+searchTerm+"%", "%"+searchTerm+"%")
+	}
+
+	if categoryID != "" {
+		// An ID that isn't a number matches no category, as no category has ID 0
+		id, _ := strconv.Atoi(categoryID)
+		whereClauses = append(whereClauses, "category_id = ?")
+		args = append(args, id)
+	}
+
+	query += " WHERE " + strings.Join(whereClauses, " AND ")
+
+	switch sortBy {
+	case domain.ProductSortRating:
+		query += " ORDER BY rating_average DESC, rating_count DESC, id"
+	default:
+		query += " ORDER BY id"
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []domain.Product
+	for rows.Next() {
+		p, err := scanProduct(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, *p)
+	}
+
+	return products, nil
+}
+
+// Get retrieves a single product
+func (r *ProductRepository) Get(ctx context.Context, id int) (*domain.Product, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = ?", id)
+
+	p, err := scanProduct(row.Scan)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrProductNotFound
+		}
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Rename changes a product's name and regenerates its slug. The old slug
+// keeps resolving to the product through slug_history.
+func (r *ProductRepository) Rename(ctx context.Context, id int, name string) (*domain.Product, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE products SET name = ? WHERE id = ?", name, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return nil, domain.ErrProductNotFound
+	}
+
+	if _, err := setSlug(ctx, tx, domain.SlugEntityProduct, id, name); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, id)
+}
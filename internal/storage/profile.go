@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"go-commerce/internal/domain"
+)
+
+// ProfileRepository stores the profile columns of the users table
+type ProfileRepository struct {
+	db *DB
+}
+
+// NewProfileRepository creates a profile repository
+func NewProfileRepository(db *DB) *ProfileRepository {
+	return &ProfileRepository{db: db}
+}
+
+// Get retrieves a user's profile. Deleted accounts have none.
+func (r *ProfileRepository) Get(ctx context.Context, userID int) (*domain.Profile, error) {
+	row := r.db.QueryRowContext(ctx, `
+		SELECT id, username, COALESCE(display_name, ''), COALESCE(avatar_url, ''), review_privacy,
+			COALESCE(email, ''), email_verified_at IS NOT NULL
+		FROM users WHERE id = ? AND deleted_at IS NULL
+	`, userID)
+
+	var p domain.Profile
+	if err := row.Scan(&p.ID, &p.Username, &p.DisplayName, &p.AvatarURL, &p.ReviewPrivacy, &p.Email, &p.EmailVerified); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Update validates and applies changes to a user's profile
+func (r *ProfileRepository) Update(ctx context.Context, userID int, update domain.ProfileUpdate) (*domain.Profile, error) {
+	update, err := update.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []string
+	var args []interface{}
+
+	if update.DisplayName != nil {
+		sets = append(sets, "display_name = ?")
+		args = append(args, *update.DisplayName)
+	}
+
+	if update.AvatarURL != nil {
+		sets = append(sets, "avatar_url = ?")
+		args = append(args, *update.AvatarURL)
+	}
+
+	if update.ReviewPrivacy != nil {
+		sets = append(sets, "review_privacy = ?")
+		args = append(args, *update.ReviewPrivacy)
+	}
+
+	if len(sets) > 0 {
+		args = append(args, userID)
+		if _, err := r.db.ExecContext(ctx, "UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.Get(ctx, userID)
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+
+	"go-commerce/internal/domain"
+)
+
+// refreshProductRating recomputes the stored rating summary of a product from
+// its approved reviews. It runs inside the transaction that changed a review
+// so the summary never disagrees with the reviews it describes.
+func refreshProductRating(ctx context.Context, tx *Tx, productID int) error {
+	approved := domain.ReviewStatusApproved
+	_, err := tx.ExecContext(ctx, `
+		UPDATE products SET
+			rating_1 = (SELECT COUNT(*) FROM reviews WHERE product_id = products.id AND status = ? AND rating = 1),
+			rating_2 = (SELECT COUNT(*) FROM reviews WHERE product_id = products.id AND status = ? AND rating = 2),
+			rating_3 = (SELECT COUNT(*) FROM reviews WHERE product_id = products.id AND status = ? AND rating = 3),
+			rating_4 = (SELECT COUNT(*) FROM reviews WHERE product_id = products.id AND status = ? AND rating = 4),
+			rating_5 = (SELECT COUNT(*) FROM reviews WHERE product_id = products.id AND status = ? AND rating = 5)
+		WHERE id = ?
+	`, approved, approved, approved, approved, approved, productID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE products SET
+			rating_count = rating_1 + rating_2 + rating_3 + rating_4 + rating_5,
+			rating_average = CASE WHEN rating_1 + rating_2 + rating_3 + rating_4 + rating_5 = 0 THEN 0
+				ELSE ROUND((rating_1 + 2.0 * rating_2 + 3.0 * rating_3 + 4.0 * rating_4 + 5.0 * rating_5)
+					/ (rating_1 + rating_2 + rating_3 + rating_4 + rating_5), 2)
+			END
+		WHERE id = ?
+	`, productID)
+	return err
+}
+
+// refreshAllProductRatings recomputes the rating summary of every product.
+// It is run at startup so databases created before ratings were stored, or
+// reviews edited by hand, are brought in line.
+func refreshAllProductRatings(ctx context.Context, db *DB) error {
+	rows, err := db.QueryContext(ctx, "SELECT id FROM products")
+	if err != nil {
+		return err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := refreshProductRating(ctx, tx, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// RefreshTokenRepository stores bearer refresh tokens in the refresh_tokens
+// table
+type RefreshTokenRepository struct {
+	db *DB
+}
+
+// NewRefreshTokenRepository creates a refresh token repository
+func NewRefreshTokenRepository(db *DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+// insertRefreshToken stores a refresh token hash in a family
+func insertRefreshToken(ctx context.Context, q querier, userID int, family, tokenHash string, createdAt, expiresAt time.Time) error {
+	_, err := q.ExecContext(ctx, "INSERT INTO refresh_tokens (user_id, family, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		userID, family, tokenHash, createdAt, expiresAt)
+	return err
+}
+
+// Create stores the first refresh token of a new family
+func (r *RefreshTokenRepository) Create(ctx context.Context, userID int, family, tokenHash string, createdAt, expiresAt time.Time) error {
+	return insertRefreshToken(ctx, r.db, userID, family, tokenHash, createdAt, expiresAt)
+}
+
+// Rotate uses up a refresh token and stores its successor in the same family.
+// A token that was already rotated coming back means whoever holds the family
+// is not to be trusted, so the whole family is revoked.
+func (r *RefreshTokenRepository) Rotate(ctx context.Context, oldHash, newHash string, now, expiresAt time.Time) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	var userID int
+	var family string
+	var oldExpiresAt time.Time
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT id, user_id, family, expires_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = ?",
+		oldHash).Scan(&id, &userID, &family, &oldExpiresAt, &usedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return 0, domain.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if usedAt.Valid && !revokedAt.Valid {
+		if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = ? WHERE family = ? AND revoked_at IS NULL", now, family); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+		return 0, domain.ErrInvalidRefreshToken
+	}
+	if usedAt.Valid || revokedAt.Valid || now.After(oldExpiresAt) {
+		tx.Rollback()
+		return 0, domain.ErrInvalidRefreshToken
+	}
+
+	// Claiming the row first means concurrent refreshes cannot both succeed
+	res, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL", now, id)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return 0, domain.ErrInvalidRefreshToken
+	}
+
+	if err := insertRefreshToken(ctx, tx, userID, family, newHash, now, expiresAt); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// RevokeFamily revokes the family of a refresh token. Unknown tokens are
+// ignored.
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, tokenHash string, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = ?
+		WHERE revoked_at IS NULL AND family = (SELECT family FROM refresh_tokens WHERE token_hash = ?)
+	`, now, tokenHash)
+	return err
+}
+
+// RevokeAllForUser revokes every refresh token of a user
+func (r *RefreshTokenRepository) RevokeAllForUser(ctx context.Context, userID int, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", now, userID)
+	return err
+}
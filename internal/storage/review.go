@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// ReviewRepository stores reviews with their votes and photos
+type ReviewRepository struct {
+	db *DB
+}
+
+// NewReviewRepository creates a review repository
+func NewReviewRepository(db *DB) *ReviewRepository {
+	return &ReviewRepository{db: db}
+}
+
+// reviewSortClauses maps review sort orders to ORDER BY clauses
+var reviewSortClauses = map[string]string{
+	domain.ReviewSortNewest:  "r.created_at DESC",
+	domain.ReviewSortHelpful: "r.helpful_count DESC, r.unhelpful_count ASC, r.created_at DESC",
+	domain.ReviewSortRating:  "r.rating DESC, r.created_at DESC",
+}
+
+// reviewColumns is the column list shared by review queries. The verified
+// purchase flag is derived from the reviewer's orders rather than stored.
+// Only the public parts of the author's profile are selected.
+const reviewColumns = `
+	r.id, r.product_id, r.user_id, r.rating, r.comment, r.created_at, r.updated_at, r.status, COALESCE(r.moderation_note, ''),
+	r.helpful_count, r.unhelpful_count, r.reply, r.replied_at,
+	EXISTS (
+		SELECT 1 FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id
+		WHERE o.user_id = r.user_id AND oi.product_id = r.product_id
+	),
+	COALESCE(u.display_name, ''), COALESCE(u.avatar_url, ''), COALESCE(u.review_privacy, ''),
+	EXISTS (SELECT 1 FROM orders o WHERE o.user_id = r.user_id)
+`
+
+// reviewFrom joins reviews to their authors for reviewColumns
+const reviewFrom = "reviews r LEFT JOIN users u ON u.id = r.user_id"
+
+// scanReview scans a row selected with reviewColumns
+func scanReview(scan func(dest ...interface{}) error) (*domain.Review, error) {
+	var r domain.Review
+	var comment sql.NullString
+	var updatedAt, repliedAt sql.NullTime
+	var reply sql.NullString
+	var displayName, avatarURL, privacy string
+	if err := scan(&r.ID, &r.ProductID, &r.UserID, &r.Rating, &comment, &r.CreatedAt, &updatedAt, &r.Status, &r.ModerationNote,
+		&r.HelpfulCount, &r.UnhelpfulCount, &reply, &repliedAt, &r.VerifiedPurchase,
+		&displayName, &avatarURL, &privacy, &r.Author.VerifiedBuyer); err != nil {
+		return nil, err
+	}
+	r.Author.DisplayName, r.Author.AvatarURL = domain.PublicAuthorName(displayName, avatarURL, privacy)
+	r.Comment = comment.String
+	if updatedAt.Valid {
+		r.UpdatedAt = &updatedAt.Time
+	}
+	if reply.Valid && reply.String != "" {
+		r.Reply = &domain.ReviewReply{Text: reply.String, RepliedAt: repliedAt.Time}
+	}
+	r.Media = []domain.ReviewMedia{}
+	return &r, nil
+}
+
+// list runs a review query and loads the photos of the reviews it returns
+func (r *ReviewRepository) list(ctx context.Context, reviews []domain.Review, query string, args ...interface{}) ([]domain.Review, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+reviewColumns+" FROM "+reviewFrom+" "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		review, err := scanReview(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, *review)
+	}
+	rows.Close()
+
+	if err := r.attachMedia(ctx, reviews); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// attachMedia loads the photos of the given reviews
+func (r *ReviewRepository) attachMedia(ctx context.Context, reviews []domain.Review) error {
+	if len(reviews) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(reviews))
+	placeholders := make([]string, len(reviews))
+	args := make([]interface{}, len(reviews))
+	for i, review := range reviews {
+		index[review.ID] = i
+		placeholders[i] = "?"
+		args[i] = review.ID
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, review_id, url, content_type FROM review_media WHERE review_id IN ("+strings.Join(placeholders, ", ")+") ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m domain.ReviewMedia
+		var reviewID int
+		if err := rows.Scan(&m.ID, &reviewID, &m.URL, &m.ContentType); err != nil {
+			return err
+		}
+		i := index[reviewID]
+		reviews[i].Media = append(reviews[i].Media, m)
+	}
+
+	return rows.Err()
+}
+
+// ListByProduct retrieves the approved reviews for a given product in the
+// given sort order, newest first by default
+func (r *ReviewRepository) ListByProduct(ctx context.Context, productID int, sortBy string) ([]domain.Review, error) {
+	if sortBy == "" {
+		sortBy = domain.ReviewSortNewest
+	}
+	orderBy, ok := reviewSortClauses[sortBy]
+	if !ok {
+		return nil, domain.ErrInvalidReviewSort
+	}
+
+	return r.list(ctx, nil, "WHERE r.product_id = ? AND r.status = ? ORDER BY "+orderBy, productID, domain.ReviewStatusApproved)
+}
+
+// ListByStatus retrieves reviews in a moderation state, oldest first, for the
+// staff moderation queue
+func (r *ReviewRepository) ListByStatus(ctx context.Context, status string) ([]domain.Review, error) {
+	return r.list(ctx, nil, "WHERE r.status = ? ORDER BY r.created_at ASC", status)
+}
+
+// ListByUser retrieves all of a user's reviews in any moderation state,
+// newest first
+func (r *ReviewRepository) ListByUser(ctx context.Context, userID int) ([]domain.Review, error) {
+	return r.list(ctx, []domain.Review{}, "WHERE r.user_id = ? ORDER BY r.created_at DESC", userID)
+}
+
+// Get retrieves a single review
+func (r *ReviewRepository) Get(ctx context.Context, id int) (*domain.Review, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+reviewColumns+" FROM "+reviewFrom+" WHERE r.id = ?", id)
+
+	review, err := scanReview(row.Scan)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrReviewNotFound
+		}
+		return nil, err
+	}
+
+	reviews := []domain.Review{*review}
+	if err := r.attachMedia(ctx, reviews); err != nil {
+		return nil, err
+	}
+
+	return &reviews[0], nil
+}
+
+// GetByMediaURL returns the review a photo is attached to, or ErrReviewNotFound
+func (r *ReviewRepository) GetByMediaURL(ctx context.Context, url string) (*domain.Review, error) {
+	var reviewID int
+	err := r.db.QueryRowContext(ctx, "SELECT review_id FROM review_media WHERE url = ?", url).Scan(&reviewID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrReviewNotFound
+		}
+		return nil, err
+	}
+
+	return r.Get(ctx, reviewID)
+}
+
+// Submit stores a user's review of a product. Each user has at most one
+// review per product, so submitting again edits the existing review. New and
+// edited reviews go back to the moderation queue. The returned bool reports
+// whether a new review was created.
+func (r *ReviewRepository) Submit(ctx context.Context, productID, userID, rating int, comment string) (*domain.Review, bool, error) {
+	comment = strings.TrimSpace(comment)
+	if err := domain.ValidateReview(rating, comment); err != nil {
+		return nil, false, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var existingID int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM reviews WHERE product_id = ? AND user_id = ? ORDER BY id LIMIT 1", productID, userID).Scan(&existingID)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return nil, false, err
+	}
+
+	now := time.Now()
+	created := err == sql.ErrNoRows
+	if created {
+		var id int64
+		err := tx.QueryRowContext(ctx, "INSERT INTO reviews (product_id, user_id, rating, comment, created_at, status) VALUES (?, ?, ?, ?, ?, ?) RETURNING id", productID, userID, rating, comment, now, domain.ReviewStatusPending).Scan(&id)
+		if err != nil {
+			tx.Rollback()
+			return nil, false, err
+		}
+		existingID = int(id)
+	} else {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE reviews
+			SET rating = ?, comment = ?, updated_at = ?, status = ?, moderated_by = NULL, moderated_at = NULL, moderation_note = NULL
+			WHERE id = ?
+		`, rating, comment, now, domain.ReviewStatusPending, existingID)
+		if err != nil {
+			tx.Rollback()
+			return nil, false, err
+		}
+	}
+
+	// An edited review leaves the rating summary until it is approved again
+	if err := refreshProductRating(ctx, tx, productID); err != nil {
+		tx.Rollback()
+		return nil, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	review, err := r.Get(ctx, existingID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return review, created, nil
+}
+
+// Moderate records a staff decision on a review. Only approved reviews are
+// shown on product pages.
+func (r *ReviewRepository) Moderate(ctx context.Context, id, moderatorID int, status, note string) (*domain.Review, error) {
+	if err := domain.ValidateReviewStatus(status); err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var productID int
+	if err := tx.QueryRowContext(ctx, "SELECT product_id FROM reviews WHERE id = ?", id).Scan(&productID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrReviewNotFound
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE reviews
+		SET status = ?, moderated_by = ?, moderated_at = ?, moderation_note = ?
+		WHERE id = ?
+	`, status, moderatorID, time.Now(), strings.TrimSpace(note), id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := refreshProductRating(ctx, tx, productID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, id)
+}
+
+// Vote records whether a user found a review helpful. Each user has one vote
+// per review; voting again replaces the earlier vote. Reviews that are not
+// approved count as not found.
+func (r *ReviewRepository) Vote(ctx context.Context, reviewID, userID int, helpful bool) (*domain.Review, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var authorID int
+	if err := tx.QueryRowContext(ctx, "SELECT user_id FROM reviews WHERE id = ? AND status = ?", reviewID, domain.ReviewStatusApproved).Scan(&authorID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrReviewNotFound
+		}
+		return nil, err
+	}
+	if authorID == userID {
+		tx.Rollback()
+		return nil, domain.ErrOwnReviewVote
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO review_votes (review_id, user_id, helpful, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(review_id, user_id) DO UPDATE SET helpful = excluded.helpful, created_at = excluded.created_at
+	`, reviewID, userID, helpful, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE reviews SET
+			helpful_count = (SELECT COUNT(*) FROM review_votes WHERE review_id = reviews.id AND helpful = TRUE),
+			unhelpful_count = (SELECT COUNT(*) FROM review_votes WHERE review_id = reviews.id AND helpful = FALSE)
+		WHERE id = ?
+	`, reviewID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, reviewID)
+}
+
+// Reply sets the merchant's official reply to a review. An empty text removes
+// the reply.
+func (r *ReviewRepository) Reply(ctx context.Context, reviewID, staffID int, text string) (*domain.Review, error) {
+	text = strings.TrimSpace(text)
+	if err := domain.ValidateReviewReply(text); err != nil {
+		return nil, err
+	}
+
+	var res sql.Result
+	var err error
+	if text == "" {
+		res, err = r.db.ExecContext(ctx, "UPDATE reviews SET reply = NULL, replied_by = NULL, replied_at = NULL WHERE id = ?", reviewID)
+	} else {
+		res, err = r.db.ExecContext(ctx, "UPDATE reviews SET reply = ?, replied_by = ?, replied_at = ? WHERE id = ?", text, staffID, time.Now(), reviewID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, domain.ErrReviewNotFound
+	}
+
+	return r.Get(ctx, reviewID)
+}
+
+// AddMedia attaches a stored photo to a review. Photos are moderated along
+// with the text, so the review returns to the queue.
+func (r *ReviewRepository) AddMedia(ctx context.Context, reviewID int, url, contentType string) (*domain.Review, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var productID int
+	if err := tx.QueryRowContext(ctx, "SELECT product_id FROM reviews WHERE id = ?", reviewID).Scan(&productID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrReviewNotFound
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO review_media (review_id, url, content_type, created_at) VALUES (?, ?, ?, ?)", reviewID, url, contentType, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE reviews SET status = ?, updated_at = ?, moderated_by = NULL, moderated_at = NULL, moderation_note = NULL WHERE id = ?", domain.ReviewStatusPending, time.Now(), reviewID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := refreshProductRating(ctx, tx, productID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(ctx, reviewID)
+}
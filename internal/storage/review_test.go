@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"go-commerce/internal/domain"
+)
+
+func TestReviewModeration(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		reviews := NewReviewRepository(db)
+		products := NewProductRepository(db)
+		alice := createUser(t, db, "alice")
+		bob := createUser(t, db, "bob")
+		staff := createUser(t, db, "staff")
+
+		review, created, err := reviews.Submit(ctx, 2, alice.ID, 5, "  Great phone ")
+		if err != nil {
+			t.Fatalf("submitting: %v", err)
+		}
+		if !created || review.Status != domain.ReviewStatusPending || review.Comment != "Great phone" {
+			t.Errorf("submitted review = %+v, created %v", review, created)
+		}
+		if _, _, err := reviews.Submit(ctx, 2, bob.ID, 6, ""); err != domain.ErrInvalidRating {
+			t.Errorf("submitting 6 stars: %v, want ErrInvalidRating", err)
+		}
+
+		// Pending reviews are neither listed nor counted
+		assertReviewCount(t, reviews, 2, 0)
+		if product, err := products.Get(ctx, 2); err != nil || product.Rating.Count != 0 {
+			t.Errorf("rating before approval = %+v, %v", product.Rating, err)
+		}
+
+		if _, err := reviews.Moderate(ctx, review.ID, staff.ID, "published", ""); err != domain.ErrInvalidReviewStatus {
+			t.Errorf("moderating to an unknown status: %v, want ErrInvalidReviewStatus", err)
+		}
+		if _, err := reviews.Moderate(ctx, 999, staff.ID, domain.ReviewStatusApproved, ""); err != domain.ErrReviewNotFound {
+			t.Errorf("moderating an unknown review: %v, want ErrReviewNotFound", err)
+		}
+		review, err = reviews.Moderate(ctx, review.ID, staff.ID, domain.ReviewStatusApproved, " fine ")
+		if err != nil {
+			t.Fatalf("approving: %v", err)
+		}
+		if review.Status != domain.ReviewStatusApproved || review.ModerationNote != "fine" {
+			t.Errorf("approved review = %+v", review)
+		}
+		assertReviewCount(t, reviews, 2, 1)
+		product, err := products.Get(ctx, 2)
+		if err != nil || product.Rating.Count != 1 || product.Rating.Average != 5 || product.Rating.Histogram["5"] != 1 {
+			t.Errorf("rating after approval = %+v, %v", product.Rating, err)
+		}
+
+		// Editing sends the review back to the queue
+		edited, created, err := reviews.Submit(ctx, 2, alice.ID, 3, "Battery is weak")
+		if err != nil {
+			t.Fatalf("editing: %v", err)
+		}
+		if created || edited.ID != review.ID || edited.Status != domain.ReviewStatusPending || edited.ModerationNote != "" || edited.UpdatedAt == nil {
+			t.Errorf("edited review = %+v, created %v", edited, created)
+		}
+		assertReviewCount(t, reviews, 2, 0)
+		if product, err := products.Get(ctx, 2); err != nil || product.Rating.Count != 0 {
+			t.Errorf("rating after editing = %+v, %v", product.Rating, err)
+		}
+		pending, err := reviews.ListByStatus(ctx, domain.ReviewStatusPending)
+		if err != nil || len(pending) != 1 || pending[0].ID != review.ID {
+			t.Errorf("moderation queue = %+v, %v", pending, err)
+		}
+	})
+}
+
+func TestReviewVotes(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		reviews := NewReviewRepository(db)
+		alice := createUser(t, db, "alice")
+		bob := createUser(t, db, "bob")
+		carol := createUser(t, db, "carol")
+		staff := createUser(t, db, "staff")
+
+		review, _, err := reviews.Submit(ctx, 7, alice.ID, 4, "Good read")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reviews.Vote(ctx, review.ID, bob.ID, true); err != domain.ErrReviewNotFound {
+			t.Errorf("voting on a pending review: %v, want ErrReviewNotFound", err)
+		}
+		if _, err := reviews.Moderate(ctx, review.ID, staff.ID, domain.ReviewStatusApproved, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := reviews.Vote(ctx, review.ID, alice.ID, true); err != domain.ErrOwnReviewVote {
+			t.Errorf("voting on your own review: %v, want ErrOwnReviewVote", err)
+		}
+		if _, err := reviews.Vote(ctx, review.ID, bob.ID, true); err != nil {
+			t.Fatalf("voting: %v", err)
+		}
+		voted, err := reviews.Vote(ctx, review.ID, carol.ID, true)
+		if err != nil || voted.HelpfulCount != 2 || voted.UnhelpfulCount != 0 {
+			t.Errorf("after two helpful votes = %+v, %v", voted, err)
+		}
+
+		// Voting again replaces the earlier vote
+		voted, err = reviews.Vote(ctx, review.ID, bob.ID, false)
+		if err != nil || voted.HelpfulCount != 1 || voted.UnhelpfulCount != 1 {
+			t.Errorf("after changing a vote = %+v, %v", voted, err)
+		}
+
+		export, err := NewAccountRepository(db).Export(ctx, bob.ID)
+		if err != nil || len(export.ReviewVotes) != 1 || export.ReviewVotes[0].Helpful {
+			t.Errorf("bob's exported votes = %+v, %v", export.ReviewVotes, err)
+		}
+	})
+}
+
+func TestReviewReplyAndMedia(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		reviews := NewReviewRepository(db)
+		alice := createUser(t, db, "alice")
+		staff := createUser(t, db, "staff")
+
+		review, _, err := reviews.Submit(ctx, 4, alice.ID, 5, "Comfy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reviews.Moderate(ctx, review.ID, staff.ID, domain.ReviewStatusApproved, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		replied, err := reviews.Reply(ctx, review.ID, staff.ID, " Thanks! ")
+		if err != nil || replied.Reply == nil || replied.Reply.Text != "Thanks!" {
+			t.Errorf("replied review = %+v, %v", replied, err)
+		}
+		if _, err := reviews.Reply(ctx, 999, staff.ID, "Thanks"); err != domain.ErrReviewNotFound {
+			t.Errorf("replying to an unknown review: %v, want ErrReviewNotFound", err)
+		}
+		cleared, err := reviews.Reply(ctx, review.ID, staff.ID, "")
+		if err != nil || cleared.Reply != nil {
+			t.Errorf("review after removing the reply = %+v, %v", cleared, err)
+		}
+
+		// A photo is moderated with the text, so the review goes back to the queue
+		withPhoto, err := reviews.AddMedia(ctx, review.ID, "/uploads/reviews/1.jpg", "image/jpeg")
+		if err != nil {
+			t.Fatalf("adding photo: %v", err)
+		}
+		if withPhoto.Status != domain.ReviewStatusPending || len(withPhoto.Media) != 1 || withPhoto.Media[0].URL != "/uploads/reviews/1.jpg" {
+			t.Errorf("review with photo = %+v", withPhoto)
+		}
+		if _, err := reviews.AddMedia(ctx, 999, "/uploads/reviews/2.jpg", "image/jpeg"); err != domain.ErrReviewNotFound {
+			t.Errorf("adding a photo to an unknown review: %v, want ErrReviewNotFound", err)
+		}
+		if found, err := reviews.GetByMediaURL(ctx, "/uploads/reviews/1.jpg"); err != nil || found.ID != review.ID {
+			t.Errorf("review by photo = %+v, %v", found, err)
+		}
+		if _, err := reviews.GetByMediaURL(ctx, "/uploads/reviews/missing.jpg"); err != domain.ErrReviewNotFound {
+			t.Errorf("review of an unknown photo: %v, want ErrReviewNotFound", err)
+		}
+		if _, err := reviews.Get(ctx, 999); err != domain.ErrReviewNotFound {
+			t.Errorf("getting an unknown review: %v, want ErrReviewNotFound", err)
+		}
+
+		mine, err := reviews.ListByUser(ctx, alice.ID)
+		if err != nil || len(mine) != 1 || len(mine[0].Media) != 1 {
+			t.Errorf("alice's reviews = %+v, %v", mine, err)
+		}
+	})
+}
+
+// assertReviewCount checks how many reviews a product page lists
+func assertReviewCount(t *testing.T, reviews *ReviewRepository, productID, want int) {
+	t.Helper()
+	listed, err := reviews.ListByProduct(context.Background(), productID, "")
+	if err != nil {
+		t.Fatalf("listing reviews: %v", err)
+	}
+	if len(listed) != want {
+		t.Errorf("product %d lists %d reviews, want %d", productID, len(listed), want)
+	}
+}
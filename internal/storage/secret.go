@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+)
+
+// SecretRepository stores the server's signing secrets in the secrets table
+type SecretRepository struct {
+	db *DB
+}
+
+// NewSecretRepository creates a secret repository
+func NewSecretRepository(db *DB) *SecretRepository {
+	return &SecretRepository{db: db}
+}
+
+// LoadOrCreate returns the named secret, storing candidate the first time it
+// is needed so the secret survives restarts
+func (r *SecretRepository) LoadOrCreate(ctx context.Context, name string, candidate []byte) ([]byte, error) {
+	var value string
+	err := r.db.QueryRowContext(ctx, "SELECT value FROM secrets WHERE name = ?", name).Scan(&value)
+	if err == nil {
+		return hex.DecodeString(value)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if _, err := r.db.ExecContext(ctx, "INSERT INTO secrets (name, value) VALUES (?, ?) ON CONFLICT (name) DO NOTHING", name, hex.EncodeToString(candidate)); err != nil {
+		return nil, err
+	}
+
+	// Another process may have created the secret first
+	if err := r.db.QueryRowContext(ctx, "SELECT value FROM secrets WHERE name = ?", name).Scan(&value); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(value)
+}
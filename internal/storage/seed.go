@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+)
+
+// SeedSampleData fills an empty catalog with sample categories and products
+// for development. Tables that already hold rows are left alone.
+func (db *DB) SeedSampleData(ctx context.Context) error {
+	count := 0
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM categories").Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err := db.ExecContext(ctx, `INSERT INTO categories (name) VALUES ('Laptops'), ('Smartphones'), ('Books'), ('T-Shirts'), ('Headphones')`)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM products").Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		_, err := db.ExecContext(ctx, `INSERT INTO products (name, description, price, image_url, category_id) VALUES
+            ('MacBook Pro', 'The latest MacBook Pro with M3 chip.', 2500.00, 'https://placeimg.com/640/480/tech', 1),
+            ('Dell XPS 15', 'A powerful and stylish Windows laptop.', 2000.00, 'https://placeimg.com/640/480/tech?2', 1),
+            ('iPhone 15 Pro', 'The latest iPhone with A17 Pro chip.', 1200.00, 'https://placeimg.com/640/480/tech?3', 2),
+            ('Samsung Galaxy S24', 'The latest Samsung phone with Galaxy AI.', 1100.00, 'https://placeimg.com/640/480/tech?4', 2),
+            ('The Pragmatic Programmer', 'Your journey to mastery, 20th Anniversary Edition.', 50.00, 'https://placeimg.com/640/480/arch', 3),
+            ('Clean Code', 'A Handbook of Agile Software Craftsmanship.', 45.00, 'https://placeimg.com/640/480/arch?2', 3),
+            ('Go-Commerce T-Shirt', 'A comfortable and stylish t-shirt for Go developers.', 30.00, 'https://placeimg.com/640/480/people', 4),
+            ('Fiber T-Shirt', 'Show your love for the Fiber framework.', 30.00, 'https://placeimg.com/640/480/people?2', 4),
+            ('Sony WH-1000XM5', 'Industry-leading noise canceling headphones.', 400.00, 'https://placeimg.com/640/480/tech?5', 5),
+            ('Bose QuietComfort Ultra', 'The next generation of noise-cancelling headphones.', 430.00, 'https://placeimg.com/640/480/tech?6', 5)
+		`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The sample rows are inserted without slugs
+	return backfillSlugs(ctx, db)
+}
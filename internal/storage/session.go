@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// SessionRepository stores the records of logged-in browser sessions in the
+// user_sessions table, keyed by a hash of the session cookie
+type SessionRepository struct {
+	db *DB
+}
+
+// NewSessionRepository creates a session repository
+func NewSessionRepository(db *DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// Start records a new session. The record of the session it replaces, if it
+// had one, and the user's sessions that have expired are forgotten.
+func (r *SessionRepository) Start(ctx context.Context, s *domain.UserSession, previousHash string, idleCutoff time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_sessions WHERE id_hash = ? OR (user_id = ? AND (expires_at <= ? OR last_seen_at <= ?))",
+		previousHash, s.UserID, s.CreatedAt, idleCutoff)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO user_sessions (user_id, id_hash, created_at, last_seen_at, expires_at, ip, user_agent) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id",
+		s.UserID, s.IDHash, s.CreatedAt, s.LastSeenAt, s.ExpiresAt, s.IP, s.UserAgent).Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.ID = int(id)
+	return nil
+}
+
+// Get retrieves the record of a user's session
+func (r *SessionRepository) Get(ctx context.Context, idHash string, userID int) (*domain.UserSession, error) {
+	s := domain.UserSession{IDHash: idHash, UserID: userID}
+	err := r.db.QueryRowContext(ctx, "SELECT id, last_seen_at, expires_at FROM user_sessions WHERE id_hash = ? AND user_id = ?", idHash, userID).
+		Scan(&s.ID, &s.LastSeenAt, &s.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Touch records that a session was used, and from where
+func (r *SessionRepository) Touch(ctx context.Context, id int, now time.Time, ip, userAgent string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE user_sessions SET last_seen_at = ?, ip = ?, user_agent = ? WHERE id = ?",
+		now, ip, userAgent, id)
+	return err
+}
+
+// DeleteByHash forgets a session's record
+func (r *SessionRepository) DeleteByHash(ctx context.Context, idHash string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE id_hash = ?", idHash)
+	return err
+}
+
+// ListActive returns a user's unexpired sessions, most recently used first
+func (r *SessionRepository) ListActive(ctx context.Context, userID int, now, idleCutoff time.Time) ([]domain.UserSession, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, id_hash, created_at, last_seen_at, expires_at, ip, COALESCE(user_agent, '')
+		FROM user_sessions
+		WHERE user_id = ? AND expires_at > ? AND last_seen_at > ?
+		ORDER BY last_seen_at DESC
+	`, userID, now, idleCutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []domain.UserSession{}
+	for rows.Next() {
+		us := domain.UserSession{UserID: userID}
+		if err := rows.Scan(&us.ID, &us.IDHash, &us.CreatedAt, &us.LastSeenAt, &us.ExpiresAt, &us.IP, &us.UserAgent); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, us)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Delete ends one of a user's sessions
+func (r *SessionRepository) Delete(ctx context.Context, id, userID int) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser ends every session of a user except the one with
+// exceptHash, which may be "" to end them all
+func (r *SessionRepository) DeleteAllForUser(ctx context.Context, userID int, exceptHash string) error {
+	var err error
+	if exceptHash == "" {
+		_, err = r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = ?", userID)
+	} else {
+		_, err = r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = ? AND id_hash != ?", userID, exceptHash)
+	}
+	return err
+}
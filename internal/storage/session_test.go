@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		sessions := NewSessionRepository(db)
+		alice := createUser(t, db, "alice")
+		bob := createUser(t, db, "bob")
+		now := time.Now()
+
+		laptop := startSession(t, db, alice.ID, "laptop", now.Add(-time.Hour))
+		phone := startSession(t, db, alice.ID, "phone", now.Add(-time.Minute))
+		startSession(t, db, bob.ID, "bob", now)
+
+		got, err := sessions.Get(ctx, "laptop", alice.ID)
+		if err != nil || got == nil || got.ID != laptop.ID {
+			t.Errorf("Get(laptop) = %+v, %v", got, err)
+		}
+		if got, err := sessions.Get(ctx, "laptop", bob.ID); got != nil || err != nil {
+			t.Errorf("getting alice's session as bob = %+v, %v; want nil", got, err)
+		}
+
+		// Using a session moves it to the top of the list
+		if err := sessions.Touch(ctx, laptop.ID, now, "198.51.100.7", "Firefox"); err != nil {
+			t.Fatalf("Touch: %v", err)
+		}
+		active, err := sessions.ListActive(ctx, alice.ID, now, now.Add(-24*time.Hour))
+		if err != nil {
+			t.Fatalf("ListActive: %v", err)
+		}
+		if len(active) != 2 || active[0].ID != laptop.ID || active[0].IP != "198.51.100.7" || active[0].UserAgent != "Firefox" || active[1].ID != phone.ID {
+			t.Errorf("active sessions = %+v, want laptop then phone", active)
+		}
+
+		// Idle sessions are not listed
+		active, err = sessions.ListActive(ctx, alice.ID, now, now.Add(-30*time.Second))
+		if err != nil || len(active) != 1 || active[0].ID != laptop.ID {
+			t.Errorf("sessions used in the last 30s = %+v, %v", active, err)
+		}
+
+		if err := sessions.Delete(ctx, phone.ID, bob.ID); err != domain.ErrSessionNotFound {
+			t.Errorf("deleting alice's session as bob: %v, want ErrSessionNotFound", err)
+		}
+		if err := sessions.Delete(ctx, phone.ID, alice.ID); err != nil {
+			t.Errorf("Delete: %v", err)
+		}
+		if err := sessions.DeleteByHash(ctx, "laptop"); err != nil {
+			t.Errorf("DeleteByHash: %v", err)
+		}
+		if got, err := sessions.Get(ctx, "laptop", alice.ID); got != nil || err != nil {
+			t.Errorf("deleted session = %+v, %v; want nil", got, err)
+		}
+	})
+}
+
+func TestSessionStartForgetsOldSessions(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		sessions := NewSessionRepository(db)
+		alice := createUser(t, db, "alice")
+		now := time.Now()
+
+		expired := &domain.UserSession{UserID: alice.ID, IDHash: "expired", CreatedAt: now.Add(-48 * time.Hour),
+			LastSeenAt: now.Add(-time.Minute), ExpiresAt: now.Add(-time.Hour)}
+		if err := sessions.Start(ctx, expired, "", now.Add(-48*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		startSession(t, db, alice.ID, "before-login", now)
+
+		// Logging in replaces the session the browser had, and expired ones go
+		replacement := &domain.UserSession{UserID: alice.ID, IDHash: "after-login", CreatedAt: now,
+			LastSeenAt: now, ExpiresAt: now.Add(time.Hour), IP: "192.0.2.1"}
+		if err := sessions.Start(ctx, replacement, "before-login", now.Add(-time.Hour)); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		var hashes []string
+		rows, err := db.QueryContext(ctx, "SELECT id_hash FROM user_sessions WHERE user_id = ?", alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var hash string
+			if err := rows.Scan(&hash); err != nil {
+				t.Fatal(err)
+			}
+			hashes = append(hashes, hash)
+		}
+		if len(hashes) != 1 || hashes[0] != "after-login" {
+			t.Errorf("stored sessions = %q, want only after-login", hashes)
+		}
+	})
+}
+
+func TestSessionDeleteAllForUser(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		sessions := NewSessionRepository(db)
+		alice := createUser(t, db, "alice")
+		bob := createUser(t, db, "bob")
+		now := time.Now()
+
+		for _, hash := range []string{"a", "b", "c"} {
+			startSession(t, db, alice.ID, hash, now)
+		}
+		startSession(t, db, bob.ID, "bob", now)
+
+		if err := sessions.DeleteAllForUser(ctx, alice.ID, "b"); err != nil {
+			t.Fatalf("DeleteAllForUser: %v", err)
+		}
+		active, err := sessions.ListActive(ctx, alice.ID, now, now.Add(-time.Hour))
+		if err != nil || len(active) != 1 || active[0].IDHash != "b" {
+			t.Errorf("alice's sessions after ending the others = %+v, %v", active, err)
+		}
+
+		if err := sessions.DeleteAllForUser(ctx, alice.ID, ""); err != nil {
+			t.Fatalf("DeleteAllForUser: %v", err)
+		}
+		if active, err := sessions.ListActive(ctx, alice.ID, now, now.Add(-time.Hour)); err != nil || len(active) != 0 {
+			t.Errorf("alice's sessions after ending all = %+v, %v", active, err)
+		}
+		if active, err := sessions.ListActive(ctx, bob.ID, now, now.Add(-time.Hour)); err != nil || len(active) != 1 {
+			t.Errorf("bob's sessions = %+v, %v", active, err)
+		}
+	})
+}
+
+// startSession records a session last used at lastSeen that expires a day
+// later
+func startSession(t *testing.T, db *DB, userID int, idHash string, lastSeen time.Time) *domain.UserSession {
+	t.Helper()
+	s := &domain.UserSession{UserID: userID, IDHash: idHash, CreatedAt: lastSeen, LastSeenAt: lastSeen,
+		ExpiresAt: lastSeen.Add(24 * time.Hour), IP: "127.0.0.1", UserAgent: "test"}
+	if err := NewSessionRepository(db).Start(context.Background(), s, "", lastSeen.Add(-24*time.Hour)); err != nil {
+		t.Fatalf("starting session %q: %v", idHash, err)
+	}
+	return s
+}
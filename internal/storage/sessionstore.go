@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SessionStorage keeps Fiber session data in the sessions table, on whichever
+// database the store runs on. It implements fiber.Storage.
+type SessionStorage struct {
+	db   *DB
+	done chan struct{}
+}
+
+// NewSessionStorage creates a session storage that deletes expired sessions
+// every gcInterval
+func NewSessionStorage(db *DB, gcInterval time.Duration) *SessionStorage {
+	s := &SessionStorage{db: db, done: make(chan struct{})}
+	go s.gc(gcInterval)
+	return s
+}
+
+// Get returns the data stored under key, or nil if there is none or it has
+// expired
+func (s *SessionStorage) Get(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	var (
+		data      []byte
+		expiresAt int64
+	)
+	err := s.db.QueryRowContext(context.Background(), "SELECT v, e FROM sessions WHERE k = ?", key).Scan(&data, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt != 0 && expiresAt <= time.Now().Unix() {
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// Set stores data under key. A zero exp keeps it until it is deleted.
+func (s *SessionStorage) Set(key string, data []byte, exp time.Duration) error {
+	if key == "" || len(data) == 0 {
+		return nil
+	}
+
+	var expiresAt int64
+	if exp != 0 {
+		expiresAt = time.Now().Add(exp).Unix()
+	}
+	_, err := s.db.ExecContext(context.Background(), `
+		INSERT INTO sessions (k, v, e) VALUES (?, ?, ?)
+		ON CONFLICT(k) DO UPDATE SET v = excluded.v, e = excluded.e
+	`, key, data, expiresAt)
+	return err
+}
+
+// Delete removes the data stored under key
+func (s *SessionStorage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+	_, err := s.db.ExecContext(context.Background(), "DELETE FROM sessions WHERE k = ?", key)
+	return err
+}
+
+// Reset removes all session data
+func (s *SessionStorage) Reset() error {
+	_, err := s.db.ExecContext(context.Background(), "DELETE FROM sessions")
+	return err
+}
+
+// Check reports an error unless session data can be read
+func (s *SessionStorage) Check(ctx context.Context) error {
+	select {
+	case <-s.done:
+		return errors.New("session store is closed")
+	default:
+	}
+	var n int
+	return s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sessions WHERE k = ?", "").Scan(&n)
+}
+
+// Close stops the removal of expired sessions. The database is shared with
+// the repositories and stays open.
+func (s *SessionStorage) Close() error {
+	close(s.done)
+	return nil
+}
+
+// gc deletes expired sessions until Close is called
+func (s *SessionStorage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.db.ExecContext(context.Background(), "DELETE FROM sessions WHERE e <= ? AND e != 0", now.Unix())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessionStorage(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		store := NewSessionStorage(db, time.Hour)
+		defer store.Close()
+
+		if err := store.Set("k1", []byte("first"), 0); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := store.Set("k1", []byte("second"), time.Hour); err != nil {
+			t.Fatalf("overwriting: %v", err)
+		}
+		if data, err := store.Get("k1"); err != nil || string(data) != "second" {
+			t.Errorf("Get(k1) = %q, %v; want second", data, err)
+		}
+
+		// Empty keys and data are not stored
+		if err := store.Set("", []byte("data"), 0); err != nil {
+			t.Errorf("Set with empty key: %v", err)
+		}
+		if err := store.Set("k2", nil, 0); err != nil {
+			t.Errorf("Set with empty data: %v", err)
+		}
+		for _, key := range []string{"", "k2", "missing"} {
+			if data, err := store.Get(key); data != nil || err != nil {
+				t.Errorf("Get(%q) = %q, %v; want nil", key, data, err)
+			}
+		}
+
+		if err := store.Delete("k1"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if data, err := store.Get("k1"); data != nil || err != nil {
+			t.Errorf("deleted key = %q, %v; want nil", data, err)
+		}
+
+		if err := store.Set("k3", []byte("x"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Reset(); err != nil {
+			t.Fatalf("Reset: %v", err)
+		}
+		if data, err := store.Get("k3"); data != nil || err != nil {
+			t.Errorf("key after Reset = %q, %v; want nil", data, err)
+		}
+	})
+}
+
+func TestSessionStorageExpiry(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		store := NewSessionStorage(db, 50*time.Millisecond)
+		defer store.Close()
+
+		// Expiry is kept in whole seconds, so this has expired once stored
+		if err := store.Set("expired", []byte("x"), time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := store.Get("expired"); data != nil || err != nil {
+			t.Errorf("expired key = %q, %v; want nil", data, err)
+		}
+
+		// The collector deletes the row as well
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var n int
+			if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM sessions WHERE k = ?", "expired").Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expired session was not collected")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
+
+func TestSessionStorageCheck(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		store := NewSessionStorage(db, time.Hour)
+		if err := store.Check(context.Background()); err != nil {
+			t.Errorf("Check: %v", err)
+		}
+		store.Close()
+		if err := store.Check(context.Background()); err == nil {
+			t.Error("Check succeeded after Close")
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// slugTables maps an entity type to the table holding its current slugs
+var slugTables = map[string]string{
+	domain.SlugEntityProduct:  "products",
+	domain.SlugEntityCategory: "categories",
+}
+
+// shownInStore restricts slug listings to the entities customers can see
+var shownInStore = map[string]string{
+	domain.SlugEntityProduct:  " AND active = TRUE",
+	domain.SlugEntityCategory: "",
+}
+
+// SlugRepository resolves slugs, current or kept in the slug_history table
+type SlugRepository struct {
+	db *DB
+}
+
+// NewSlugRepository creates a slug repository
+func NewSlugRepository(db *DB) *SlugRepository {
+	return &SlugRepository{db: db}
+}
+
+// uniqueSlug returns a slug for name that is not used by any other entity of
+// the same type, either as a current slug or as one kept in slug_history
+func uniqueSlug(ctx context.Context, tx *Tx, entityType, name string, entityID int) (string, error) {
+	table := slugTables[entityType]
+	base := domain.Slugify(name)
+	if base == "" {
+		base = entityType
+	}
+
+	for n := 1; ; n++ {
+		candidate := base
+		if n > 1 {
+			candidate = fmt.Sprintf("%s-%d", base, n)
+		}
+
+		var count int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE slug = ? AND id != ?", candidate, entityID).Scan(&count)
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			continue
+		}
+
+		err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM slug_history WHERE entity_type = ? AND slug = ? AND entity_id != ?", entityType, candidate, entityID).Scan(&count)
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+}
+
+// setSlug gives an entity a new slug derived from name. The previous slug, if
+// any, is kept in slug_history so old URLs can redirect to the new one.
+func setSlug(ctx context.Context, tx *Tx, entityType string, entityID int, name string) (string, error) {
+	table := slugTables[entityType]
+
+	var oldSlug sql.NullString
+	if err := tx.QueryRowContext(ctx, "SELECT slug FROM "+table+" WHERE id = ?", entityID).Scan(&oldSlug); err != nil {
+		return "", err
+	}
+
+	slug, err := uniqueSlug(ctx, tx, entityType, name, entityID)
+	if err != nil {
+		return "", err
+	}
+	if oldSlug.Valid && oldSlug.String == slug {
+		return slug, nil
+	}
+
+	if oldSlug.Valid && oldSlug.String != "" {
+		_, err = tx.ExecContext(ctx, "INSERT INTO slug_history (entity_type, entity_id, slug, created_at) VALUES (?, ?, ?, ?) ON CONFLICT (entity_type, slug) DO UPDATE SET entity_id = excluded.entity_id, created_at = excluded.created_at", entityType, entityID, oldSlug.String, time.Now())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The new slug is current again, so it no longer belongs in the history
+	if _, err := tx.ExecContext(ctx, "DELETE FROM slug_history WHERE entity_type = ? AND slug = ?", entityType, slug); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET slug = ? WHERE id = ?", slug, entityID); err != nil {
+		return "", err
+	}
+
+	return slug, nil
+}
+
+// Resolve looks up an entity by slug. It returns the entity ID and the
+// entity's current slug, which differs from the given one when the slug comes
+// from slug_history. An ID of 0 means the slug is unknown or, like the
+// sitemap, the entity is not shown in the store.
+func (r *SlugRepository) Resolve(ctx context.Context, entityType, slug string) (int, string, error) {
+	table := slugTables[entityType]
+
+	var id int
+	err := r.db.QueryRowContext(ctx, "SELECT id FROM "+table+" WHERE slug = ?"+shownInStore[entityType], slug).Scan(&id)
+	if err == nil {
+		return id, slug, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, "", err
+	}
+
+	var current string
+	err = r.db.QueryRowContext(ctx, `
+		SELECT t.id, t.slug
+		FROM slug_history h
+		JOIN `+table+` t ON t.id = h.entity_id
+		WHERE h.entity_type = ? AND h.slug = ?`+shownInStore[entityType],
+		entityType, slug).Scan(&id, &current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", nil // Not found
+		}
+		return 0, "", err
+	}
+
+	return id, current, nil
+}
+
+// Slugs lists the current slugs of the categories, or of the active
+// products, in ID order
+func (r *SlugRepository) Slugs(ctx context.Context, entityType string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT slug FROM "+slugTables[entityType]+" WHERE slug IS NOT NULL AND slug != ''"+shownInStore[entityType]+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+
+	return slugs, rows.Err()
+}
+
+// backfillSlugs assigns slugs to products and categories that don't have one
+func backfillSlugs(ctx context.Context, db *DB) error {
+	for _, entityType := range []string{domain.SlugEntityCategory, domain.SlugEntityProduct} {
+		table := slugTables[entityType]
+
+		rows, err := db.QueryContext(ctx, "SELECT id, name FROM "+table+" WHERE slug IS NULL OR slug = '' ORDER BY id")
+		if err != nil {
+			return err
+		}
+
+		type pending struct {
+			id   int
+			name string
+		}
+		var missing []pending
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.name); err != nil {
+				rows.Close()
+				return err
+			}
+			missing = append(missing, p)
+		}
+		rows.Close()
+
+		for _, p := range missing {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if _, err := setSlug(ctx, tx, entityType, p.id, p.name); err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+
+	"go-commerce/internal/domain"
+)
+
+// The storage tests run every scenario once on SQLite and once on
+// PostgreSQL. PostgreSQL is started from embedded-postgres, which downloads
+// its binaries on first use, unless TEST_POSTGRES_URL names a server to use
+// instead; each test gets a database of its own there. With -short, or when
+// the embedded server cannot be started, for example without network access,
+// only SQLite is tested.
+
+// postgresURL is the server the PostgreSQL tests create their databases on.
+// It is empty when PostgreSQL is not tested, for the reason in postgresSkipped.
+var postgresURL string
+
+var postgresSkipped = "PostgreSQL is not tested with -short"
+
+// postgresDatabases numbers the databases made for tests
+var postgresDatabases atomic.Int64
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	os.Exit(runTests(m))
+}
+
+// runTests runs the tests with a PostgreSQL server, if one is needed
+func runTests(m *testing.M) int {
+	if testing.Short() {
+		return m.Run()
+	}
+
+	postgresURL = os.Getenv("TEST_POSTGRES_URL")
+	if postgresURL == "" {
+		url, stop, err := startPostgres()
+		if err != nil {
+			postgresSkipped = "embedded PostgreSQL could not be started; set TEST_POSTGRES_URL to test on a running server"
+			fmt.Fprintf(os.Stderr, "starting embedded PostgreSQL: %v\nTesting SQLite only.\n", err)
+			return m.Run()
+		}
+		defer stop()
+		postgresURL = url
+	}
+	return m.Run()
+}
+
+// startPostgres starts an embedded PostgreSQL server on a free port and
+// returns its URL and a function that stops it
+func startPostgres() (string, func(), error) {
+	port, err := freePort()
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "go-commerce-postgres-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	var logs bytes.Buffer
+	config := embeddedpostgres.DefaultConfig().
+		Version(embeddedpostgres.V16).
+		Port(uint32(port)).
+		RuntimePath(filepath.Join(dir, "runtime")).
+		StartTimeout(time.Minute).
+		Logger(&logs)
+	server := embeddedpostgres.NewDatabase(config)
+	if err := server.Start(); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("%w\n%s", err, logs.String())
+	}
+
+	stop := func() {
+		if err := server.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "stopping embedded PostgreSQL: %v\n", err)
+		}
+		os.RemoveAll(dir)
+	}
+	return config.GetConnectionURL() + "?sslmode=disable", stop, nil
+}
+
+// freePort returns a TCP port nothing listens on
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// forEachDatabase runs test on a new, empty database of each dialect, given
+// as the DSN to Open it with
+func forEachDatabase(t *testing.T, test func(t *testing.T, dialect Dialect, dsn string)) {
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, DialectSQLite, filepath.Join(t.TempDir(), "store.db"))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		if postgresURL == "" {
+			t.Skip(postgresSkipped)
+		}
+		test(t, DialectPostgres, createPostgresDatabase(t))
+	})
+}
+
+// forEachDialect runs test on a new database of each dialect, opened and so
+// migrated and holding the sample data
+func forEachDialect(t *testing.T, test func(t *testing.T, db *DB)) {
+	forEachDatabase(t, func(t *testing.T, dialect Dialect, dsn string) {
+		test(t, openTestDB(t, dialect, dsn))
+	})
+}
+
+// createPostgresDatabase creates an empty database on the test server,
+// dropped again when the test ends, and returns its URL
+func createPostgresDatabase(t *testing.T) string {
+	t.Helper()
+	admin, err := sql.Open("pgx", postgresURL)
+	if err != nil {
+		t.Fatalf("connecting to PostgreSQL: %v", err)
+	}
+	defer admin.Close()
+
+	name := fmt.Sprintf("go_commerce_test_%d_%d", os.Getpid(), postgresDatabases.Add(1))
+	if _, err := admin.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("creating database %s: %v", name, err)
+	}
+	// Registered before the test opens the database, so it runs after the
+	// database is closed
+	t.Cleanup(func() {
+		admin, err := sql.Open("pgx", postgresURL)
+		if err != nil {
+			t.Errorf("connecting to PostgreSQL: %v", err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.Exec("DROP DATABASE IF EXISTS " + name); err != nil {
+			t.Errorf("dropping database %s: %v", name, err)
+		}
+	})
+
+	u, err := url.Parse(postgresURL)
+	if err != nil {
+		t.Fatalf("parsing TEST_POSTGRES_URL: %v", err)
+	}
+	u.Path = "/" + name
+	return u.String()
+}
+
+// openTestDB opens a database holding the sample data for the rest of the
+// test
+func openTestDB(t *testing.T, dialect Dialect, dsn string) *DB {
+	t.Helper()
+	db, err := Open(dialect, dsn)
+	if err != nil {
+		t.Fatalf("opening %s database: %v", dialect, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.SeedSampleData(context.Background()); err != nil {
+		t.Fatalf("seeding %s database: %v", dialect, err)
+	}
+	return db
+}
+
+// createUser stores a customer
+func createUser(t *testing.T, db *DB, username string) *domain.User {
+	t.Helper()
+	user, err := NewUserRepository(db).Create(context.Background(), username, "hash-of-"+username)
+	if err != nil {
+		t.Fatalf("creating user %q: %v", username, err)
+	}
+	return user
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// LoginThrottleRepository stores failed login counters in the login_throttle
+// table and the audit log in login_attempts
+type LoginThrottleRepository struct {
+	db *DB
+}
+
+// NewLoginThrottleRepository creates a login throttle repository
+func NewLoginThrottleRepository(db *DB) *LoginThrottleRepository {
+	return &LoginThrottleRepository{db: db}
+}
+
+// Get retrieves the counters kept for a throttle key
+func (r *LoginThrottleRepository) Get(ctx context.Context, key string) (*domain.ThrottleState, error) {
+	var s domain.ThrottleState
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT failures, last_failure_at, locked_until FROM login_throttle WHERE key = ?", key).
+		Scan(&s.Failures, &s.LastFailureAt, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		s.LockedUntil = &lockedUntil.Time
+	}
+
+	return &s, nil
+}
+
+// AddFailure counts a failure against a throttle key in a single statement,
+// so concurrent failures cannot overwrite each other's count. Failures from
+// before forgetBefore and lockouts that have ended by now are forgotten
+// first. It returns the counters as they are after the failure.
+func (r *LoginThrottleRepository) AddFailure(ctx context.Context, key string, now, forgetBefore time.Time) (*domain.ThrottleState, error) {
+	var s domain.ThrottleState
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO login_throttle (key, failures, last_failure_at, locked_until) VALUES (?, 1, ?, NULL)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttle.locked_until IS NOT NULL AND login_throttle.locked_until <= ? THEN 1
+				WHEN login_throttle.locked_until IS NULL AND login_throttle.last_failure_at < ? THEN 1
+				ELSE login_throttle.failures + 1
+			END,
+			locked_until = CASE WHEN login_throttle.locked_until <= ? THEN NULL ELSE login_throttle.locked_until END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures, last_failure_at, locked_until
+	`, key, now, now, forgetBefore, now).Scan(&s.Failures, &s.LastFailureAt, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		s.LockedUntil = &lockedUntil.Time
+	}
+
+	return &s, nil
+}
+
+// Lock locks a throttle key until the given time
+func (r *LoginThrottleRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE login_throttle SET locked_until = ? WHERE key = ?", until, key)
+	return err
+}
+
+// Delete forgets the counters of a throttle key
+func (r *LoginThrottleRepository) Delete(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM login_throttle WHERE key = ?", key)
+	return err
+}
+
+// RecordAttempt writes a failed login to the audit log
+func (r *LoginThrottleRepository) RecordAttempt(ctx context.Context, attempt domain.LoginAttempt) error {
+	var uid interface{}
+	if attempt.UserID != 0 {
+		uid = attempt.UserID
+	}
+	_, err := r.db.ExecContext(ctx, "INSERT INTO login_attempts (username, user_id, ip, user_agent, reason, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		attempt.Username, uid, attempt.IP, attempt.UserAgent, attempt.Reason, attempt.CreatedAt)
+	return err
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go-commerce/internal/auth"
+	"go-commerce/internal/domain"
+)
+
+func TestLoginThrottleAddFailure(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		throttle := NewLoginThrottleRepository(db)
+		now := time.Now()
+		window := 15 * time.Minute
+
+		if state, err := throttle.Get(ctx, "ip:192.0.2.1"); state != nil || err != nil {
+			t.Errorf("unknown key = %+v, %v; want nil", state, err)
+		}
+
+		for want := 1; want <= 3; want++ {
+			state, err := throttle.AddFailure(ctx, "ip:192.0.2.1", now, now.Add(-window))
+			if err != nil {
+				t.Fatalf("AddFailure: %v", err)
+			}
+			if state.Failures != want || state.LockedUntil != nil {
+				t.Errorf("after failure %d: %+v", want, state)
+			}
+		}
+
+		// A lockout is kept while it lasts and counts further failures
+		lockedUntil := now.Add(window)
+		if err := throttle.Lock(ctx, "ip:192.0.2.1", lockedUntil); err != nil {
+			t.Fatalf("Lock: %v", err)
+		}
+		state, err := throttle.AddFailure(ctx, "ip:192.0.2.1", now, now.Add(-window))
+		if err != nil || state.Failures != 4 || state.LockedUntil == nil || state.LockedUntil.Sub(lockedUntil).Abs() > time.Second {
+			t.Errorf("failure while locked = %+v, %v", state, err)
+		}
+
+		// Once it has ended the count starts over
+		later := lockedUntil.Add(time.Second)
+		state, err = throttle.AddFailure(ctx, "ip:192.0.2.1", later, later.Add(-window))
+		if err != nil || state.Failures != 1 || state.LockedUntil != nil {
+			t.Errorf("failure after the lockout = %+v, %v", state, err)
+		}
+
+		// So does a count whose last failure is older than the window
+		muchLater := later.Add(time.Hour)
+		state, err = throttle.AddFailure(ctx, "ip:192.0.2.1", muchLater, muchLater.Add(-window))
+		if err != nil || state.Failures != 1 {
+			t.Errorf("failure after the window = %+v, %v", state, err)
+		}
+
+		if err := throttle.Delete(ctx, "ip:192.0.2.1"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if state, err := throttle.Get(ctx, "ip:192.0.2.1"); state != nil || err != nil {
+			t.Errorf("deleted key = %+v, %v; want nil", state, err)
+		}
+	})
+}
+
+func TestLoginThrottleConcurrentFailures(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		repo := NewLoginThrottleRepository(db)
+		cfg := auth.DefaultLoginThrottleConfig()
+		throttle := auth.NewLoginThrottle(repo, cfg)
+
+		// A burst of wrong guesses all arriving before any was counted
+		const guesses = 20
+		var wg sync.WaitGroup
+		errs := make(chan error, guesses)
+		for i := 0; i < guesses; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- throttle.RecordFailure(ctx, "alice", "192.0.2.1", "", 0, auth.LoginFailureUnknownUser)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("RecordFailure: %v", err)
+			}
+		}
+
+		state, err := repo.Get(ctx, "user:alice")
+		if err != nil || state == nil {
+			t.Fatalf("account counters = %+v, %v", state, err)
+		}
+		if state.Failures != guesses || state.LockedUntil == nil {
+			t.Errorf("after %d parallel failures: %d counted, locked until %v", guesses, state.Failures, state.LockedUntil)
+		}
+		if wait, err := throttle.Check(ctx, "alice", "198.51.100.1"); err != nil || wait < cfg.LockoutDuration-time.Minute {
+			t.Errorf("wait after the burst = %v, %v; want the lockout", wait, err)
+		}
+	})
+}
+
+func TestLoginThrottleRecordAttempt(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		throttle := NewLoginThrottleRepository(db)
+		alice := createUser(t, db, "alice")
+		now := time.Now()
+
+		attempts := []domain.LoginAttempt{
+			{Username: "alice", UserID: alice.ID, IP: "192.0.2.1", UserAgent: "curl", Reason: "bad_password", CreatedAt: now},
+			{Username: "nobody", IP: "192.0.2.1", Reason: "unknown_user", CreatedAt: now},
+		}
+		for _, attempt := range attempts {
+			if err := throttle.RecordAttempt(ctx, attempt); err != nil {
+				t.Fatalf("RecordAttempt(%s): %v", attempt.Username, err)
+			}
+		}
+
+		// Attempts on unknown usernames belong to nobody
+		export, err := NewAccountRepository(db).Export(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("Export: %v", err)
+		}
+		if len(export.LoginAttempts) != 1 || export.LoginAttempts[0].Reason != "bad_password" || export.LoginAttempts[0].UserAgent != "curl" {
+			t.Errorf("alice's failed logins = %+v", export.LoginAttempts)
+		}
+		var orphans int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM login_attempts WHERE user_id IS NULL").Scan(&orphans); err != nil {
+			t.Fatal(err)
+		}
+		if orphans != 1 {
+			t.Errorf("%d attempts without a user, want 1", orphans)
+		}
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		limit := auth.NewRateLimit(NewLoginThrottleRepository(db), 3, time.Hour)
+
+		for i := 1; i <= 3; i++ {
+			if wait, err := limit.Allow(ctx, "reset-email:alice@example.com"); wait != 0 || err != nil {
+				t.Fatalf("request %d: wait %v, %v; want allowed", i, wait, err)
+			}
+		}
+		for i := 4; i <= 5; i++ {
+			if wait, err := limit.Allow(ctx, "reset-email:alice@example.com"); err != nil || wait < 59*time.Minute {
+				t.Errorf("request %d: wait %v, %v; want about an hour", i, wait, err)
+			}
+		}
+
+		// Other keys have limits of their own
+		if wait, err := limit.Allow(ctx, "reset-email:bob@example.com"); wait != 0 || err != nil {
+			t.Errorf("other key: wait %v, %v; want allowed", wait, err)
+		}
+	})
+}
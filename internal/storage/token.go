@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// UserTokenRepository stores the single-use tokens sent by email in the
+// user_tokens table
+type UserTokenRepository struct {
+	db *DB
+}
+
+// NewUserTokenRepository creates a user token repository
+func NewUserTokenRepository(db *DB) *UserTokenRepository {
+	return &UserTokenRepository{db: db}
+}
+
+// Create stores a token for a user. Earlier unused tokens of the same purpose
+// are revoked so only the latest email link works.
+func (r *UserTokenRepository) Create(ctx context.Context, userID int, purpose, email string, createdAt, expiresAt time.Time) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL", createdAt, userID, purpose)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, "INSERT INTO user_tokens (user_id, purpose, email, created_at, expires_at) VALUES (?, ?, ?, ?, ?) RETURNING id", userID, purpose, email, createdAt, expiresAt).Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Redeem marks a token used and, inside the same transaction, passes its user
+// and email address to use. The token stays unused if use fails, so a
+// rejected request (say, a password that fails the policy) can be retried
+// with the same link.
+func (r *UserTokenRepository) Redeem(ctx context.Context, id int64, purpose string, use func(users domain.UserRepository, userID int, email string) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Claiming the row first means concurrent redemptions cannot both succeed
+	res, err := tx.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE id = ? AND purpose = ? AND used_at IS NULL", time.Now(), id, purpose)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return domain.ErrInvalidToken
+	}
+
+	var userID int
+	var email string
+	if err := tx.QueryRowContext(ctx, "SELECT user_id, COALESCE(email, '') FROM user_tokens WHERE id = ?", id).Scan(&userID, &email); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := use(&UserRepository{q: tx}, userID, email); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+func TestUserTokenRedeem(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		tokens := NewUserTokenRepository(db)
+		alice := createUser(t, db, "alice")
+		now := time.Now()
+
+		first, err := tokens.Create(ctx, alice.ID, "password_reset", "alice@example.com", now, now.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("creating token: %v", err)
+		}
+		latest, err := tokens.Create(ctx, alice.ID, "password_reset", "alice@example.com", now, now.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("creating another token: %v", err)
+		}
+
+		// Only the latest link works, and only for its purpose
+		noop := func(domain.UserRepository, int, string) error { return nil }
+		if err := tokens.Redeem(ctx, first, "password_reset", noop); err != domain.ErrInvalidToken {
+			t.Errorf("redeeming a replaced token: %v, want ErrInvalidToken", err)
+		}
+		if err := tokens.Redeem(ctx, latest, "verify_email", noop); err != domain.ErrInvalidToken {
+			t.Errorf("redeeming for another purpose: %v, want ErrInvalidToken", err)
+		}
+
+		// A failed use leaves the token to be tried again
+		rejected := errors.New("password too short")
+		err = tokens.Redeem(ctx, latest, "password_reset", func(users domain.UserRepository, userID int, email string) error {
+			return rejected
+		})
+		if err != rejected {
+			t.Errorf("failed redemption: %v, want the error from use", err)
+		}
+
+		err = tokens.Redeem(ctx, latest, "password_reset", func(users domain.UserRepository, userID int, email string) error {
+			if userID != alice.ID || email != "alice@example.com" {
+				t.Errorf("token for user %d and %q, want %d and alice@example.com", userID, email, alice.ID)
+			}
+			return users.SetPassword(ctx, userID, "new-hash")
+		})
+		if err != nil {
+			t.Fatalf("redeeming: %v", err)
+		}
+		user, err := NewUserRepository(db).GetByID(ctx, alice.ID)
+		if err != nil || user.Password != "new-hash" {
+			t.Errorf("password after redeeming = %q, %v", user.Password, err)
+		}
+		if err := tokens.Redeem(ctx, latest, "password_reset", noop); err != domain.ErrInvalidToken {
+			t.Errorf("redeeming twice: %v, want ErrInvalidToken", err)
+		}
+	})
+}
+
+func TestRefreshTokenRotate(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		tokens := NewRefreshTokenRepository(db)
+		alice := createUser(t, db, "alice")
+		now := time.Now()
+		expiresAt := now.Add(time.Hour)
+
+		if err := tokens.Create(ctx, alice.ID, "family-1", "hash-1", now, expiresAt); err != nil {
+			t.Fatalf("creating token: %v", err)
+		}
+		userID, err := tokens.Rotate(ctx, "hash-1", "hash-2", now, expiresAt)
+		if err != nil || userID != alice.ID {
+			t.Fatalf("rotating = %d, %v; want %d", userID, err, alice.ID)
+		}
+		if _, err := tokens.Rotate(ctx, "unknown", "hash-x", now, expiresAt); err != domain.ErrInvalidRefreshToken {
+			t.Errorf("rotating an unknown token: %v, want ErrInvalidRefreshToken", err)
+		}
+
+		// Reusing a rotated token revokes the whole family
+		if _, err := tokens.Rotate(ctx, "hash-1", "hash-3", now, expiresAt); err != domain.ErrInvalidRefreshToken {
+			t.Errorf("reusing a rotated token: %v, want ErrInvalidRefreshToken", err)
+		}
+		if _, err := tokens.Rotate(ctx, "hash-2", "hash-3", now, expiresAt); err != domain.ErrInvalidRefreshToken {
+			t.Errorf("rotating after reuse: %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+}
+
+func TestRefreshTokenExpiryAndRevocation(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		tokens := NewRefreshTokenRepository(db)
+		alice := createUser(t, db, "alice")
+		now := time.Now()
+
+		if err := tokens.Create(ctx, alice.ID, "expired", "expired-hash", now.Add(-2*time.Hour), now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokens.Rotate(ctx, "expired-hash", "next", now, now.Add(time.Hour)); err != domain.ErrInvalidRefreshToken {
+			t.Errorf("rotating an expired token: %v, want ErrInvalidRefreshToken", err)
+		}
+
+		for _, family := range []string{"laptop", "phone", "tablet"} {
+			if err := tokens.Create(ctx, alice.ID, family, family+"-hash", now, now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tokens.RevokeFamily(ctx, "laptop-hash", now); err != nil {
+			t.Fatalf("revoking family: %v", err)
+		}
+		if err := tokens.RevokeFamily(ctx, "unknown-hash", now); err != nil {
+			t.Errorf("revoking an unknown family: %v", err)
+		}
+		if _, err := tokens.Rotate(ctx, "laptop-hash", "laptop-next", now, now.Add(time.Hour)); err != domain.ErrInvalidRefreshToken {
+			t.Errorf("rotating a revoked token: %v, want ErrInvalidRefreshToken", err)
+		}
+		if _, err := tokens.Rotate(ctx, "phone-hash", "phone-next", now, now.Add(time.Hour)); err != nil {
+			t.Errorf("rotating another family: %v", err)
+		}
+
+		if err := tokens.RevokeAllForUser(ctx, alice.ID, now); err != nil {
+			t.Fatalf("revoking all: %v", err)
+		}
+		for _, hash := range []string{"phone-next", "tablet-hash"} {
+			if _, err := tokens.Rotate(ctx, hash, hash+"-2", now, now.Add(time.Hour)); err != domain.ErrInvalidRefreshToken {
+				t.Errorf("rotating %s after revoking all: %v, want ErrInvalidRefreshToken", hash, err)
+			}
+		}
+	})
+}
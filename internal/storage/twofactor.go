@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// TwoFactorRepository stores TOTP secrets in the users table and recovery
+// code hashes in recovery_codes
+type TwoFactorRepository struct {
+	db *DB
+}
+
+// NewTwoFactorRepository creates a two-factor repository
+func NewTwoFactorRepository(db *DB) *TwoFactorRepository {
+	return &TwoFactorRepository{db: db}
+}
+
+// Get retrieves a user's two-factor setup
+func (r *TwoFactorRepository) Get(ctx context.Context, userID int) (*domain.TwoFactorState, error) {
+	var s domain.TwoFactorState
+	var secret, pending sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT username, totp_secret, totp_pending_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = ?", userID).
+		Scan(&s.Username, &secret, &pending, &s.Enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+	s.Secret = secret.String
+	s.PendingSecret = pending.String
+
+	return &s, nil
+}
+
+// SetPendingSecret stores the secret of an enrollment that has not been
+// confirmed yet
+func (r *TwoFactorRepository) SetPendingSecret(ctx context.Context, userID int, secret string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET totp_pending_secret = ? WHERE id = ?", secret, userID)
+	return err
+}
+
+// Enable makes the pending secret the user's secret and replaces their
+// recovery codes
+func (r *TwoFactorRepository) Enable(ctx context.Context, userID int, step int64, codeHashes []string, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET totp_secret = totp_pending_secret, totp_pending_secret = NULL, totp_enabled_at = ?, totp_last_step = ?
+		WHERE id = ?
+	`, now, step, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimStep records a TOTP time step as used. Only a step later than the
+// last one used is accepted, so a code seen by someone else cannot be
+// replayed within its validity window.
+func (r *TwoFactorRepository) ClaimStep(ctx context.Context, userID int, step int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, "UPDATE users SET totp_last_step = ? WHERE id = ? AND COALESCE(totp_last_step, -1) < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode marks an unused recovery code used
+func (r *TwoFactorRepository) UseRecoveryCode(ctx context.Context, userID int, codeHash string, now time.Time) (bool, error) {
+	res, err := r.db.ExecContext(ctx, "UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		now, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and stores new ones
+func replaceRecoveryCodes(ctx context.Context, tx *Tx, userID int, codeHashes []string, now time.Time) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)", userID, hash, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces a user's recovery codes, invalidating the old ones
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CountRecoveryCodes counts a user's unused recovery codes
+func (r *TwoFactorRepository) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	return n, err
+}
+
+// Disable deletes the user's TOTP secrets and recovery codes
+func (r *TwoFactorRepository) Disable(ctx context.Context, userID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET totp_secret = NULL, totp_pending_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+		WHERE id = ?
+	`, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+// UserRepository stores user accounts in the users table
+type UserRepository struct {
+	q querier
+}
+
+// NewUserRepository creates a user repository
+func NewUserRepository(db *DB) *UserRepository {
+	return &UserRepository{q: db}
+}
+
+// getUser retrieves the first user matching a condition on the users table.
+// Deleted accounts are left out.
+func (r *UserRepository) getUser(ctx context.Context, where string, args ...interface{}) (*domain.User, error) {
+	row := r.q.QueryRowContext(ctx, "SELECT id, username, password, role FROM users WHERE deleted_at IS NULL AND "+where, args...)
+
+	var user domain.User
+	if err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Not found
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Create stores a new customer. The username is stored normalized;
+// ErrUsernameTaken is returned if it exists in any letter case.
+func (r *UserRepository) Create(ctx context.Context, username, passwordHash string) (*domain.User, error) {
+	username = domain.NormalizeUsername(username)
+
+	existing, err := r.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, domain.ErrUsernameTaken
+	}
+
+	var id int
+	err = r.q.QueryRowContext(ctx, "INSERT INTO users (username, password) VALUES (?, ?) RETURNING id", username, passwordHash).Scan(&id)
+	if err != nil {
+		// Another registration may have taken the name since the check above
+		if isUniqueViolation(err) {
+			return nil, domain.ErrUsernameTaken
+		}
+		return nil, err
+	}
+
+	return &domain.User{ID: id, Username: username, Password: passwordHash, Role: domain.RoleCustomer}, nil
+}
+
+// GetByID retrieves a user by their ID
+func (r *UserRepository) GetByID(ctx context.Context, id int) (*domain.User, error) {
+	return r.getUser(ctx, "id = ?", id)
+}
+
+// GetByUsername retrieves a user by their username, ignoring letter case
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return r.getUser(ctx, r.q.Dialect().equalFold("username")+" ORDER BY id LIMIT 1", domain.NormalizeUsername(username))
+}
+
+// GetByEmail retrieves a user by their email address, ignoring letter case
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return r.getUser(ctx, r.q.Dialect().equalFold("email")+" ORDER BY id LIMIT 1", email)
+}
+
+// GetByVerifiedEmail retrieves the user who verified an email address
+func (r *UserRepository) GetByVerifiedEmail(ctx context.Context, email string) (*domain.User, error) {
+	return r.getUser(ctx, r.q.Dialect().equalFold("email")+" AND email_verified_at IS NOT NULL ORDER BY id LIMIT 1", email)
+}
+
+// SetRole changes the role of the user with the given username
+func (r *UserRepository) SetRole(ctx context.Context, username, role string) error {
+	if err := domain.ValidateRole(role); err != nil {
+		return err
+	}
+
+	res, err := r.q.ExecContext(ctx, "UPDATE users SET role = ? WHERE username = ?", role, username)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetEmail changes a user's email address. A new address starts out
+// unverified.
+func (r *UserRepository) SetEmail(ctx context.Context, userID int, email string) error {
+	email, err := domain.NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	existing, err := r.GetByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != userID {
+		return domain.ErrEmailTaken
+	}
+	if existing != nil {
+		return nil // Unchanged
+	}
+
+	_, err = r.q.ExecContext(ctx, "UPDATE users SET email = ?, email_verified_at = NULL WHERE id = ?", email, userID)
+	return err
+}
+
+// MarkEmailVerified marks a user's address verified if it is still the given
+// one, and reports whether it was
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, userID int, email string) (bool, error) {
+	res, err := r.q.ExecContext(ctx, "UPDATE users SET email_verified_at = ? WHERE id = ? AND "+r.q.Dialect().equalFold("email"), time.Now(), userID, email)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetPassword replaces a user's password hash
+func (r *UserRepository) SetPassword(ctx context.Context, userID int, passwordHash string) error {
+	_, err := r.q.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", passwordHash, userID)
+	return err
+}
+
+// ReplacePasswordHash stores a new hash of the same password. Matching the
+// old hash avoids overwriting a password changed meanwhile.
+func (r *UserRepository) ReplacePasswordHash(ctx context.Context, userID int, oldHash, newHash string) error {
+	_, err := r.q.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND password = ?", newHash, userID, oldHash)
+	return err
+}
+
+// ResetPassword sets a new password if email is still the user's address.
+// Receiving the reset email proves the user controls the address, so it
+// counts as verified.
+func (r *UserRepository) ResetPassword(ctx context.Context, userID int, email, passwordHash string) (bool, error) {
+	res, err := r.q.ExecContext(ctx, "UPDATE users SET password = ?, email_verified_at = COALESCE(email_verified_at, ?) WHERE id = ? AND "+r.q.Dialect().equalFold("email"), passwordHash, time.Now(), userID, email)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-commerce/internal/domain"
+)
+
+func TestUserUsernamesIgnoreCase(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		users := NewUserRepository(db)
+
+		alice, err := users.Create(ctx, "  Alice ", "hash")
+		if err != nil {
+			t.Fatalf("creating user: %v", err)
+		}
+		if alice.Username != "alice" || alice.Role != domain.RoleCustomer {
+			t.Errorf("created user = %+v, want customer alice", alice)
+		}
+
+		if _, err := users.Create(ctx, "ALICE", "hash"); err != domain.ErrUsernameTaken {
+			t.Errorf("creating ALICE: %v, want ErrUsernameTaken", err)
+		}
+		found, err := users.GetByUsername(ctx, "aLiCe")
+		if err != nil || found == nil || found.ID != alice.ID {
+			t.Errorf("GetByUsername(aLiCe) = %+v, %v", found, err)
+		}
+		if missing, err := users.GetByUsername(ctx, "bob"); missing != nil || err != nil {
+			t.Errorf("GetByUsername(bob) = %+v, %v; want nil", missing, err)
+		}
+
+		if err := users.SetRole(ctx, "alice", domain.RoleStaff); err != nil {
+			t.Fatalf("SetRole: %v", err)
+		}
+		found, err = users.GetByID(ctx, alice.ID)
+		if err != nil || found.Role != domain.RoleStaff {
+			t.Errorf("role after SetRole = %+v, %v", found, err)
+		}
+		if err := users.SetRole(ctx, "alice", "owner"); err != domain.ErrInvalidRole {
+			t.Errorf("SetRole(owner): %v, want ErrInvalidRole", err)
+		}
+	})
+}
+
+func TestUserEmail(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		users := NewUserRepository(db)
+		alice := createUser(t, db, "alice")
+		bob := createUser(t, db, "bob")
+
+		if err := users.SetEmail(ctx, alice.ID, "Alice@Example.com"); err != nil {
+			t.Fatalf("SetEmail: %v", err)
+		}
+		if err := users.SetEmail(ctx, bob.ID, "alice@example.COM"); err != domain.ErrEmailTaken {
+			t.Errorf("taking alice's address in other case: %v, want ErrEmailTaken", err)
+		}
+
+		found, err := users.GetByEmail(ctx, "ALICE@example.com")
+		if err != nil || found == nil || found.ID != alice.ID {
+			t.Errorf("GetByEmail = %+v, %v", found, err)
+		}
+		if found, err := users.GetByVerifiedEmail(ctx, "alice@example.com"); found != nil || err != nil {
+			t.Errorf("GetByVerifiedEmail before verifying = %+v, %v; want nil", found, err)
+		}
+
+		// Only the address the link was sent to can be verified
+		if ok, err := users.MarkEmailVerified(ctx, alice.ID, "old@example.com"); ok || err != nil {
+			t.Errorf("verifying another address = %v, %v; want false", ok, err)
+		}
+		if ok, err := users.MarkEmailVerified(ctx, alice.ID, "alice@example.com"); !ok || err != nil {
+			t.Errorf("verifying the address = %v, %v; want true", ok, err)
+		}
+		found, err = users.GetByVerifiedEmail(ctx, "alice@example.com")
+		if err != nil || found == nil || found.ID != alice.ID {
+			t.Errorf("GetByVerifiedEmail = %+v, %v", found, err)
+		}
+
+		// A new address starts out unverified
+		if err := users.SetEmail(ctx, alice.ID, "alice@example.org"); err != nil {
+			t.Fatalf("changing address: %v", err)
+		}
+		profile, err := NewProfileRepository(db).Get(ctx, alice.ID)
+		if err != nil || profile.Email != "alice@example.org" || profile.EmailVerified {
+			t.Errorf("profile after changing address = %+v, %v", profile, err)
+		}
+
+		// A reset verifies the address it was sent to
+		if ok, err := users.ResetPassword(ctx, alice.ID, "alice@example.com", "new-hash"); ok || err != nil {
+			t.Errorf("reset sent to the old address = %v, %v; want false", ok, err)
+		}
+		if ok, err := users.ResetPassword(ctx, alice.ID, "alice@example.org", "new-hash"); !ok || err != nil {
+			t.Errorf("reset = %v, %v; want true", ok, err)
+		}
+		found, err = users.GetByVerifiedEmail(ctx, "alice@example.org")
+		if err != nil || found == nil || found.Password != "new-hash" {
+			t.Errorf("user after reset = %+v, %v", found, err)
+		}
+	})
+}
+
+func TestUserReplacePasswordHash(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		users := NewUserRepository(db)
+		alice := createUser(t, db, "alice")
+
+		if err := users.ReplacePasswordHash(ctx, alice.ID, alice.Password, "rehashed"); err != nil {
+			t.Fatal(err)
+		}
+		// A password changed meanwhile is not overwritten
+		if err := users.SetPassword(ctx, alice.ID, "changed"); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.ReplacePasswordHash(ctx, alice.ID, "rehashed", "stale"); err != nil {
+			t.Fatal(err)
+		}
+		found, err := users.GetByID(ctx, alice.ID)
+		if err != nil || found.Password != "changed" {
+			t.Errorf("password hash = %q, %v; want changed", found.Password, err)
+		}
+	})
+}
+
+func TestProfileUpdate(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		profiles := NewProfileRepository(db)
+		alice := createUser(t, db, "alice")
+
+		name, privacy := "  Alice A ", domain.ReviewPrivacyAnonymous
+		profile, err := profiles.Update(ctx, alice.ID, domain.ProfileUpdate{DisplayName: &name, ReviewPrivacy: &privacy})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if profile.DisplayName != "Alice A" || profile.ReviewPrivacy != domain.ReviewPrivacyAnonymous || profile.AvatarURL != "" {
+			t.Errorf("updated profile = %+v", profile)
+		}
+
+		bad := "hidden"
+		if _, err := profiles.Update(ctx, alice.ID, domain.ProfileUpdate{ReviewPrivacy: &bad}); err != domain.ErrInvalidReviewPrivacy {
+			t.Errorf("invalid privacy: %v, want ErrInvalidReviewPrivacy", err)
+		}
+		if missing, err := profiles.Get(ctx, 999); missing != nil || err != nil {
+			t.Errorf("Get(999) = %+v, %v; want nil", missing, err)
+		}
+	})
+}
+
+func TestIdentities(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		identities := NewIdentityRepository(db)
+		alice := createUser(t, db, "alice")
+
+		// New logins get a username of their own and keep a free address
+		user, err := identities.CreateUser(ctx, domain.ExternalUser{
+			Username: "Alice", DisplayName: "Alice G", VerifiedEmail: "alice@example.com",
+			Provider: "google", Subject: "g-1", IdentityEmail: "alice@example.com",
+		})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		if user.Username != "Alice2" {
+			t.Errorf("new login's username = %q, want Alice2", user.Username)
+		}
+		found, err := NewUserRepository(db).GetByVerifiedEmail(ctx, "alice@example.com")
+		if err != nil || found == nil || found.ID != user.ID {
+			t.Errorf("new login's verified address belongs to %+v, %v", found, err)
+		}
+		if id, err := identities.FindUser(ctx, "google", "g-1"); id != user.ID || err != nil {
+			t.Errorf("FindUser = %d, %v; want %d", id, err, user.ID)
+		}
+
+		if err := identities.Link(ctx, alice.ID, "google", "g-1", ""); err != domain.ErrIdentityInUse {
+			t.Errorf("linking someone else's login: %v, want ErrIdentityInUse", err)
+		}
+		if err := identities.Link(ctx, alice.ID, "github", "gh-1", "alice@example.net"); err != nil {
+			t.Fatalf("Link: %v", err)
+		}
+		if err := identities.Link(ctx, alice.ID, "github", "gh-1", "alice@example.net"); err != nil {
+			t.Errorf("linking again: %v", err)
+		}
+		linked, err := identities.ListByUser(ctx, alice.ID)
+		if err != nil || len(linked) != 1 || linked[0].Provider != "github" || linked[0].Email != "alice@example.net" {
+			t.Errorf("alice's logins = %+v, %v", linked, err)
+		}
+
+		// An account without a password keeps its last login
+		if err := identities.Unlink(ctx, user.ID, "google"); err != domain.ErrLastLoginMethod {
+			t.Errorf("unlinking the only login: %v, want ErrLastLoginMethod", err)
+		}
+		if err := identities.Unlink(ctx, alice.ID, "google"); err != domain.ErrIdentityNotLinked {
+			t.Errorf("unlinking a login that is not linked: %v, want ErrIdentityNotLinked", err)
+		}
+		if err := identities.Unlink(ctx, alice.ID, "github"); err != nil {
+			t.Errorf("unlinking with a password: %v", err)
+		}
+	})
+}
+
+func TestAccountDelete(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, db *DB) {
+		ctx := context.Background()
+		users := NewUserRepository(db)
+		alice := createUser(t, db, "alice")
+		bob := createUser(t, db, "bob")
+		now := time.Now()
+
+		if err := users.SetEmail(ctx, alice.ID, "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		review, _, err := NewReviewRepository(db).Submit(ctx, 1, alice.ID, 5, "Great")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewIdentityRepository(db).Link(ctx, alice.ID, "github", "gh-1", ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewRefreshTokenRepository(db).Create(ctx, alice.ID, "family", "token-hash", now, now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewLoginThrottleRepository(db).AddFailure(ctx, "user:alice", now, now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		startSession(t, db, bob.ID, "bob-session", now)
+		startSession(t, db, alice.ID, "alice-session", now)
+
+		export, err := NewAccountRepository(db).Export(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("Export: %v", err)
+		}
+		if !export.HasPassword || len(export.Reviews) != 1 || len(export.LinkedLogins) != 1 || len(export.EmailAddresses) != 1 {
+			t.Errorf("export before deleting = %+v", export)
+		}
+
+		if err := NewAccountRepository(db).Delete(ctx, alice.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+
+		// The row stays for the orders and reviews, but lookups no longer find it
+		var username, password string
+		if err := db.QueryRowContext(ctx, "SELECT username, password FROM users WHERE id = ?", alice.ID).Scan(&username, &password); err != nil {
+			t.Fatal(err)
+		}
+		if username != deletedUsername(alice.ID) || password != "" {
+			t.Errorf("deleted user's row has username %q and password %q", username, password)
+		}
+		if found, err := users.GetByID(ctx, alice.ID); found != nil || err != nil {
+			t.Errorf("GetByID of the deleted user = %+v, %v; want nil", found, err)
+		}
+		if profile, err := NewProfileRepository(db).Get(ctx, alice.ID); profile != nil || err != nil {
+			t.Errorf("deleted user's profile = %+v, %v; want nil", profile, err)
+		}
+		if found, err := users.GetByEmail(ctx, "alice@example.com"); found != nil || err != nil {
+			t.Errorf("deleted user's address still finds %+v, %v", found, err)
+		}
+		// The name and address are free again, and the tombstone cannot be taken
+		if _, err := users.Create(ctx, "alice", "hash"); err != nil {
+			t.Errorf("registering the deleted username: %v", err)
+		}
+		if err := users.SetEmail(ctx, bob.ID, "alice@example.com"); err != nil {
+			t.Errorf("taking the deleted user's address: %v", err)
+		}
+
+		// Reviews stay, shown as anonymous
+		review, err = NewReviewRepository(db).Get(ctx, review.ID)
+		if err != nil || review == nil || review.Author.DisplayName != domain.AnonymousAuthorName {
+			t.Errorf("deleted user's review = %+v, %v", review, err)
+		}
+
+		for _, table := range []string{"user_identities", "refresh_tokens", "user_sessions"} {
+			var n int
+			if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE user_id = ?", alice.ID).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			if n != 0 {
+				t.Errorf("%d rows left in %s", n, table)
+			}
+		}
+		if state, err := NewLoginThrottleRepository(db).Get(ctx, "user:alice"); state != nil || err != nil {
+			t.Errorf("throttle state left: %+v, %v", state, err)
+		}
+		if sessions, err := NewSessionRepository(db).ListActive(ctx, bob.ID, now, now.Add(-time.Hour)); err != nil || len(sessions) != 1 {
+			t.Errorf("bob's sessions after deleting alice = %+v, %v", sessions, err)
+		}
+	})
+}
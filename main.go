@@ -1,307 +1,355 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
-	"strconv"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
-	"github.com/gofiber/storage/sqlite3"
-)
-
-func main() {
-	// Initialize database
-	db, err := InitDB("./database/store.db")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	// Initialize session store
-	store := session.New(session.Config{
-		Storage: sqlite3.New(sqlite3.Config{
-			Database: "./database/store.db",
-			Table:    "sessions",
-		}),
-		Expiration: 24 * time.Hour,
-	})
-
-	app := fiber.New()
-
-	app.Static("/", "./public")
-
-	api := app.Group("/api")
-
-	// Products endpoints
-	api.Get("/products", func(c *fiber.Ctx) error {
-		searchTerm := c.Query("search")
-		categoryID := c.Query("category")
-		products, err := GetProducts(db, searchTerm, categoryID)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		return c.JSON(products)
-	})
 
-	api.Get("/products/:id", func(c *fiber.Ctx) error {
-		id, err := strconv.Atoi(c.Params("id"))
-		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid product ID")
-		}
-
-		product, err := GetProduct(db, id)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		if product == nil {
-			return fiber.NewError(fiber.StatusNotFound, "Product not found")
-		}
-
-		return c.JSON(product)
-	})
+	"go-commerce/internal/auth"
+	"go-commerce/internal/config"
+	"go-commerce/internal/httpapi"
+	"go-commerce/internal/logging"
+	"go-commerce/internal/mail"
+	"go-commerce/internal/media"
+	"go-commerce/internal/metrics"
+	"go-commerce/internal/mockoidc"
+	"go-commerce/internal/storage"
+	"go-commerce/internal/tracing"
+)
 
-	// Reviews endpoints
-	api.Get("/products/:id/reviews", func(c *fiber.Ctx) error {
-		id, err := strconv.Atoi(c.Params("id"))
-		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid product ID")
-		}
+// Set at build time with
+// -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD)".
+// Without them the commit comes from the Go toolchain's VCS stamp.
+var (
+	version = "dev"
+	commit  = ""
+)
 
-		reviews, err := GetReviewsByProductID(db, id)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+// buildInfo describes this build for /version
+func buildInfo() httpapi.BuildInfo {
+	info := httpapi.BuildInfo{Version: version, Commit: commit, SchemaVersion: storage.SchemaVersion}
+	if info.Commit == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			modified := false
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					info.Commit = s.Value
+				case "vcs.modified":
+					modified = s.Value == "true"
+				}
+			}
+			if modified && info.Commit != "" {
+				info.Commit += "-dirty"
+			}
 		}
-
-		return c.JSON(reviews)
-	})
-
-	type CreateReviewRequest struct {
-		Rating  int    `json:"rating"`
-		Comment string `json:"comment"`
 	}
+	return info
+}
 
-	api.Post("/products/:id/reviews", func(c *fiber.Ctx) error {
-		id, err := strconv.Atoi(c.Params("id"))
-		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid product ID")
-		}
-
-		sess, err := store.Get(c)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-
-		userID, ok := sess.Get("userID").(int)
-		if !ok {
-			return fiber.NewError(fiber.StatusUnauthorized, "Not logged in")
-		}
-
-		var req CreateReviewRequest
-		if err := c.BodyParser(&req); err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
-		}
-
-		review, err := CreateReview(db, id, userID, req.Rating, req.Comment)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-
-		return c.JSON(review)
-	})
-
-	// Categories endpoint
-	api.Get("/categories", func(c *fiber.Ctx) error {
-		categories, err := GetCategories(db)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		return c.JSON(categories)
-	})
-
-	// Cart endpoints
-	api.Post("/cart", func(c *fiber.Ctx) error {
-		res, err := db.Exec("INSERT INTO carts DEFAULT VALUES")
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		id, err := res.LastInsertId()
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		return c.JSON(fiber.Map{"id": id})
-	})
+// fatal logs an error that keeps the server from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
 
-	api.Get("/cart/:id", func(c *fiber.Ctx) error {
-		id, err := strconv.Atoi(c.Params("id"))
-		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid cart ID")
+func main() {
+	ctx := context.Background()
+
+	// Settings come from ./config/config.json (or -config), the profile file
+	// for the environment, environment variables and flags, in increasing
+	// order of precedence. "go-commerce config print" shows the result.
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	build := buildInfo()
+	opts, err := newOptions(cfg, build.Version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 && args[0] == "config" {
+		if len(args) != 2 || args[1] != "print" {
+			log.Fatal("usage: config print")
 		}
-
-		cart, err := GetCart(db, id)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
 
-		return c.JSON(cart)
-	})
-
-	type AddToCartRequest struct {
-		ProductID int `json:"productId"`
-		Quantity  int `json:"quantity"`
+	switch {
+	case len(args) == 0:
+	case args[0] == "set-role" && len(args) == 3:
+	case args[0] == "set-role":
+		log.Fatal("usage: set-role <username> <customer|staff|admin>")
+	default:
+		log.Fatalf("unknown command %q", args[0])
 	}
 
-	api.Post("/cart/:id/items", func(c *fiber.Ctx) error {
-		id, err := strconv.Atoi(c.Params("id"))
-		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid cart ID")
-		}
+	// Structured logs go to standard error, JSON unless log.format is text
+	logger, err := logging.New(os.Stderr, opts.logLevel, cfg.Log.Format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
 
-		var req AddToCartRequest
-		if err := c.BodyParser(&req); err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
-		}
+	// Spans go to an OpenTelemetry collector with TRACING_EXPORTER=otlp, or
+	// to standard output with TRACING_EXPORTER=stdout
+	shutdownTracing, err := tracing.Setup(ctx, opts.tracing)
+	if err != nil {
+		fatal("setting up tracing", err)
+	}
 
-		if err := AddItemToCart(db, id, req.ProductID, req.Quantity); err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	// Initialize database
+	db, err := storage.Open(opts.dialect, cfg.Database.URL)
+	if err != nil {
+		fatal("opening database", err)
+	}
+	defer db.Close()
+	if cfg.Database.SeedSampleData {
+		if err := db.SeedSampleData(ctx); err != nil {
+			fatal("seeding sample data", err)
 		}
-
-		return c.SendStatus(fiber.StatusCreated)
+	}
+	metrics.RegisterDB(db.DB)
+
+	products := storage.NewProductRepository(db)
+	categories := storage.NewCategoryRepository(db)
+	slugs := storage.NewSlugRepository(db)
+	carts := storage.NewCartRepository(db)
+	orders := storage.NewOrderRepository(db)
+	reviews := storage.NewReviewRepository(db)
+	users := storage.NewUserRepository(db)
+	profiles := storage.NewProfileRepository(db)
+	secrets := storage.NewSecretRepository(db)
+	twoFactor := storage.NewTwoFactorRepository(db)
+	identities := storage.NewIdentityRepository(db)
+	apiKeys := storage.NewAPIKeyRepository(db)
+
+	// Administrative commands, e.g. "go-commerce set-role alice staff"
+	if len(args) > 0 && args[0] == "set-role" {
+		if err := users.SetRole(ctx, args[1], args[2]); err != nil {
+			fatal("setting role", err)
+		}
+		fmt.Printf("%s is now %s\n", args[1], args[2])
+		return
+	}
+	// Cookies are only sent over HTTPS when the public URL is an https:// one
+	publicURL := cfg.PublicURL()
+	secureCookies := cfg.SecureCookies()
+
+	// Initialize session store, kept in the same database. Sessions expire
+	// after the idle timeout without use and the maximum lifetime after login
+	// in any case.
+	sessionConfig := opts.sessions
+	sessionStorage := storage.NewSessionStorage(db, time.Duration(cfg.Session.GCInterval))
+	store := session.New(session.Config{
+		Storage:        sessionStorage,
+		Expiration:     sessionConfig.IdleTimeout,
+		KeyLookup:      "cookie:" + cfg.Session.CookieName,
+		CookieSecure:   secureCookies,
+		CookieHTTPOnly: true,
+		// Lax rather than Strict so the session survives the redirect back
+		// from an OpenID Connect provider
+		CookieSameSite: fiber.CookieSameSiteLaxMode,
 	})
+	sessions := httpapi.NewSessions(storage.NewSessionRepository(db), store, sessionConfig)
 
-	// Auth endpoints
-	api.Get("/me", func(c *fiber.Ctx) error {
-		sess, err := store.Get(c)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-
-		userID, ok := sess.Get("userID").(int)
-		if !ok {
-			return c.JSON(fiber.Map{"loggedIn": false})
-		}
-
-		return c.JSON(fiber.Map{"loggedIn": true, "userID": userID})
-	})
+	// Cookie-authenticated API requests must carry a CSRF token
+	csrfSecret, err := auth.LoadOrCreateSecret(ctx, secrets, "csrf")
+	if err != nil {
+		fatal("loading CSRF secret", err)
+	}
+	csrf := httpapi.NewCSRF(csrfSecret, cfg.Session.CookieName, secureCookies)
 
-	type AuthRequest struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+	// Username and password rules, with an optional list of common passwords
+	credentialPolicy := opts.policy
+	if err := credentialPolicy.LoadBlocklist(cfg.Auth.PasswordBlocklist); err != nil && !os.IsNotExist(err) {
+		fatal("loading password blocklist", err)
 	}
 
-	api.Post("/register", func(c *fiber.Ctx) error {
-		var req AuthRequest
-		if err := c.BodyParser(&req); err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
-		}
+	// Password hashing, e.g. PASSWORD_HASH_ALGORITHM=argon2id. Hashes made
+	// with other settings are upgraded when their owners next log in.
+	if err := auth.ConfigurePasswordHashing(opts.hashing); err != nil {
+		fatal("configuring password hashing", err)
+	}
 
-		user, err := CreateUser(db, req.Username, req.Password)
+	// Outgoing email goes to an SMTP server when one is set (for example a
+	// local MailHog on localhost:1025), to a file when one is set and to
+	// standard output otherwise
+	var mailer mail.Mailer = mail.NewStdoutMailer(cfg.Mail.From)
+	if cfg.Mail.SMTPAddr != "" {
+		mailer = &mail.SMTPMailer{
+			Addr:     cfg.Mail.SMTPAddr,
+			From:     cfg.Mail.From,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+		}
+	} else if cfg.Mail.File != "" {
+		mailer, err = mail.NewFileMailer(cfg.Mail.From, cfg.Mail.File)
 		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+			fatal("opening mail file", err)
 		}
+	}
 
-		return c.JSON(user)
-	})
+	tokens, err := auth.NewTokenSigner(ctx, secrets, storage.NewUserTokenRepository(db))
+	if err != nil {
+		fatal("loading email token secret", err)
+	}
+	// Password reset requests are rate limited in the login throttle table
+	loginThrottleRepo := storage.NewLoginThrottleRepository(db)
+	accountEmails := auth.NewAccountEmails(users, profiles, mailer, tokens, credentialPolicy, loginThrottleRepo, publicURL)
 
-	api.Post("/login", func(c *fiber.Ctx) error {
-		var req AuthRequest
-		if err := c.BodyParser(&req); err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
-		}
+	loginThrottle := auth.NewLoginThrottle(loginThrottleRepo, auth.DefaultLoginThrottleConfig())
 
-		user, err := GetUserByUsername(db, req.Username)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		if user == nil || !CheckPasswordHash(req.Password, user.Password) {
-			return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
-		}
+	apiTokens, err := auth.NewAPITokens(ctx, secrets, storage.NewRefreshTokenRepository(db))
+	if err != nil {
+		fatal("loading access token secret", err)
+	}
 
-		sess, err := store.Get(c)
+	// OpenID Connect login providers come from a JSON file. OIDC_MOCK=1 adds
+	// a built-in mock provider for offline development, served at
+	// PUBLIC_URL/mock-oidc.
+	oidcProviders, err := auth.LoadOIDCProviders(cfg.Auth.OIDCProvidersFile)
+	if err != nil && !os.IsNotExist(err) {
+		fatal("loading OpenID Connect providers", err)
+	}
+	var mockOIDC *mockoidc.Provider
+	if cfg.Auth.OIDCMock {
+		mockOIDC, err = mockoidc.New(publicURL+"/mock-oidc", "go-commerce", "mock-secret")
 		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		sess.Set("userID", user.ID)
-		if err := sess.Save(); err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+			fatal("starting mock OpenID Connect provider", err)
 		}
+		oidcProviders = append(oidcProviders, mockOIDC.Config())
+		slog.Warn("mock OpenID Connect provider enabled; do not use in production")
+	}
+	oidcLogin, err := auth.NewOIDCLogin(oidcProviders, credentialPolicy)
+	if err != nil {
+		fatal("setting up OpenID Connect login", err)
+	}
 
-		return c.JSON(fiber.Map{"message": "Login successful"})
+	// Review photos are stored below UploadsDir and served at /uploads by the
+	// review handler, which hides them until their review is approved
+	mediaStore := media.NewLocalStorage(cfg.Server.UploadsDir, "/uploads")
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler:          httpapi.ErrorHandler,
+		DisableStartupMessage: true,
+		// c.IP() is the client's address behind the configured proxies, and
+		// the connection's address otherwise, so a header cannot pick it
+		ProxyHeader:             cfg.Server.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.Server.TrustedProxies,
+		EnableIPValidation:      true,
 	})
-
-	api.Post("/logout", func(c *fiber.Ctx) error {
-		sess, err := store.Get(c)
-		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		if err := sess.Destroy(); err != nil {
-		    return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		return c.SendStatus(fiber.StatusNoContent)
+	app.Use(httpapi.Tracing(), httpapi.RequestLogger(), httpapi.RequestMetrics())
+
+	// Load balancers poll /readyz, which checks the database and session
+	// store and reports ready once the server listens
+	health := httpapi.NewHealthHandler(build,
+		httpapi.HealthCheck{Name: "database", Check: db.PingContext},
+		httpapi.HealthCheck{Name: "schema", Check: db.CheckSchema},
+		httpapi.HealthCheck{Name: "sessions", Check: sessionStorage.Check},
+	)
+	health.Register(app)
+	app.Hooks().OnListen(func(fiber.ListenData) error {
+		slog.Info("listening", "addr", cfg.Server.Addr, "public_url", publicURL, "env", cfg.Env)
+		health.SetReady(true)
+		return nil
 	})
 
-	// Orders endpoints
-	type CreateOrderRequest struct {
-		CartID int `json:"cartId"`
+	// Prometheus scrapes /metrics, sending METRICS_TOKEN, which only
+	// development may leave unset
+	if cfg.Metrics.Enabled {
+		httpapi.NewMetricsHandler(cfg.Metrics.Token).Register(app)
 	}
 
-	api.Post("/orders", func(c *fiber.Ctx) error {
-		log.Println("Received request to create order")
-		sess, err := store.Get(c)
-		if err != nil {
-			log.Printf("Error getting session: %v", err)
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-
-		userID, ok := sess.Get("userID").(int)
-		if !ok {
-			log.Println("User not logged in")
-			return fiber.NewError(fiber.StatusUnauthorized, "Not logged in")
-		}
-
-		var req CreateOrderRequest
-		if err := c.BodyParser(&req); err != nil {
-			log.Printf("Error parsing request body: %v", err)
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
-		}
-
-		order, err := CreateOrder(db, req.CartID, userID)
-		if err != nil {
-			log.Printf("Error creating order: %v", err)
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-		if order == nil {
-			log.Println("Cannot create an empty order")
-			return fiber.NewError(fiber.StatusBadRequest, "Cannot create an empty order")
-		}
-
-		log.Printf("Order created successfully: %v", order)
-		return c.JSON(order)
-	})
-
-	api.Get("/orders", func(c *fiber.Ctx) error {
-		log.Println("Received request to get orders")
-		sess, err := store.Get(c)
-		if err != nil {
-			log.Printf("Error getting session: %v", err)
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
-
-		userID, ok := sess.Get("userID").(int)
-		if !ok {
-			log.Println("User not logged in")
-			return fiber.NewError(fiber.StatusUnauthorized, "Not logged in")
-		}
-
-		orders, err := GetOrdersByUserID(db, userID)
-		if err != nil {
-			log.Printf("Error getting orders by user ID: %v", err)
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
-		}
+	httpapi.NewStorefrontHandler(slugs, filepath.Join(cfg.Server.StaticDir, "index.html"), publicURL).Register(app)
 
-		log.Printf("Returning %d orders for user %d", len(orders), userID)
-		return c.JSON(orders)
-	})
+	if mockOIDC != nil {
+		mockOIDC.Register(app.Group("/mock-oidc"))
+	}
 
-	app.Listen(":3000")
-}
\ No newline at end of file
+	authMiddleware := httpapi.AuthMiddleware(sessions, apiTokens, apiKeys, users)
+	reviewHandler := httpapi.NewReviewHandler(reviews, products, users, twoFactor, mediaStore)
+	reviewHandler.RegisterPhotos(app.Group("/uploads", authMiddleware))
+	app.Static("/", cfg.Server.StaticDir)
+
+	// Every API request goes through the CSRF check and AuthMiddleware, which
+	// finds the user from a bearer token, an API key or the session cookie.
+	// Routes that API keys may use name the scope they need with RequireScope.
+	api := app.Group("/api", csrf.Middleware(), authMiddleware)
+
+	httpapi.NewCatalogHandler(products, categories, slugs, users, twoFactor).Register(api)
+	reviewHandler.Register(api)
+	httpapi.NewCartHandler(carts).Register(api)
+	httpapi.NewProfileHandler(profiles).Register(api)
+	httpapi.NewAuthHandler(httpapi.AuthDependencies{
+		Users:      users,
+		Profiles:   profiles,
+		TwoFactor:  twoFactor,
+		Identities: identities,
+		Accounts:   storage.NewAccountRepository(db),
+		Sessions:   sessions,
+		Tokens:     apiTokens,
+		Throttle:   loginThrottle,
+		Emails:     accountEmails,
+		Policy:     credentialPolicy,
+		OIDC:       oidcLogin,
+		PublicURL:  publicURL,
+	}).Register(api)
+	httpapi.NewAPIKeyHandler(apiKeys, users).Register(api)
+	httpapi.NewOrderHandler(orders).Register(api)
+
+	// Serve until SIGINT or SIGTERM, then shut down in order: stop being
+	// ready, let requests in flight finish, stop the session store and close
+	// the database
+	signals, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Listen(cfg.Server.Addr)
+	}()
+	select {
+	case err := <-served:
+		fatal("listening", err)
+	case <-signals.Done():
+	}
+	// A second signal stops the process right away
+	stop()
+
+	slog.Info("shutting down")
+	health.SetReady(false)
+	time.Sleep(time.Duration(cfg.Server.ShutdownDelay))
+	if err := app.ShutdownWithTimeout(time.Duration(cfg.Server.ShutdownTimeout)); err != nil {
+		slog.Error("shutting down server", "error", err)
+	}
+	if err := sessionStorage.Close(); err != nil {
+		slog.Error("closing session store", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("closing database", "error", err)
+	}
+	flushCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("flushing traces", "error", err)
+	}
+	cancel()
+	slog.Info("shutdown complete")
+}
@@ -1,3 +1,30 @@
+// API calls that change something must send back the CSRF token the server
+// keeps in the csrf_token cookie
+const csrfToken = () => {
+    const cookie = document.cookie.split('; ').find(c => c.startsWith('csrf_token='));
+    return cookie ? cookie.substring('csrf_token='.length) : '';
+};
+const plainFetch = window.fetch.bind(window);
+window.fetch = (resource, options = {}) => {
+    const method = (options.method || 'GET').toUpperCase();
+    if (!['GET', 'HEAD', 'OPTIONS'].includes(method)) {
+        const headers = new Headers(options.headers);
+        headers.set('X-CSRF-Token', csrfToken());
+        options = { ...options, headers };
+    }
+    return plainFetch(resource, options);
+};
+
+// Error responses carry {"error": {"code", "message"}}; anything else, such
+// as a proxy's error page, falls back to the status text
+const errorMessage = async response => {
+    try {
+        return (await response.json()).error.message;
+    } catch {
+        return response.statusText;
+    }
+};
+
 
 document.addEventListener('DOMContentLoaded', () => {
     // Keep non-Vue related modal initializations and other variables for now
@@ -105,6 +132,7 @@ document.addEventListener('DOMContentLoaded', () => {
                 });
 
                 if (response.ok) {
+                    alert('Thanks! Your review will appear once it has been approved.');
                     // Refresh reviews
                     const reviewsResponse = await fetch(`/api/products/${this.selectedProduct.id}/reviews`);
                     this.reviews = await reviewsResponse.json() || [];
@@ -114,6 +142,26 @@ document.addEventListener('DOMContentLoaded', () => {
                     alert('Failed to submit review.');
                 }
             },
+            async voteReview(reviewId, helpful) {
+                if (!this.loggedIn) {
+                    alert('You must be logged in to vote on reviews.');
+                    loginModal.show();
+                    return;
+                }
+
+                const response = await fetch(`/api/reviews/${reviewId}/vote`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ helpful })
+                });
+
+                if (response.ok) {
+                    const updated = await response.json();
+                    this.reviews = this.reviews.map(review => review.id === updated.id ? updated : review);
+                } else {
+                    alert('Failed to record your vote.');
+                }
+            },
             async renderCartItems() {
                 const cartId = await getOrCreateCart();
                 const response = await fetch(`/api/cart/${cartId}`);
@@ -175,7 +223,7 @@ document.addEventListener('DOMContentLoaded', () => {
                     alert('Registration successful! Please log in.');
                     this.showLoginForm();
                 } else {
-                    alert('Registration failed.');
+                    alert(`Registration failed: ${await errorMessage(response)}`);
                 }
             },
             showRegisterForm() {
@@ -264,25 +312,74 @@ document.addEventListener('DOMContentLoaded', () => {
             body: JSON.stringify({ username, password })
         });
 
-        if (response.ok) {
-            alert('Login successful!');
-            checkLoginStatus();
-            loginModal.hide();
-        } else {
+        if (!response.ok) {
             alert('Invalid credentials');
+            return;
         }
+
+        const result = await response.json();
+        if (result.twoFactorRequired && !await completeTwoFactorLogin()) return;
+        if (result.twoFactorSetupRequired && !await setUpTwoFactor()) return;
+
+        alert('Login successful!');
+        checkLoginStatus();
+        loginModal.hide();
     });
 
+    // Ask for the second factor after a correct password
+    const completeTwoFactorLogin = async () => {
+        const code = prompt('Enter the code from your authenticator app, or a recovery code:');
+        if (!code) return false;
+
+        const response = await fetch('/api/login/2fa', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ code })
+        });
+        if (!response.ok) {
+            alert(`Login failed: ${await errorMessage(response)}`);
+            return false;
+        }
+        return true;
+    };
+
+    // Enroll in two-factor authentication, which some accounts must do before logging in
+    const setUpTwoFactor = async () => {
+        const enrollResponse = await fetch('/api/2fa/enroll', { method: 'POST' });
+        if (!enrollResponse.ok) {
+            alert(`Two-factor setup failed: ${await errorMessage(enrollResponse)}`);
+            return false;
+        }
+        const enrollment = await enrollResponse.json();
+
+        const code = prompt(`This account requires two-factor authentication. Add this key to your authenticator app:\n\n${enrollment.secret}\n\nThen enter the code it shows:`);
+        if (!code) return false;
+
+        const confirmResponse = await fetch('/api/2fa/confirm', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ code })
+        });
+        if (!confirmResponse.ok) {
+            alert(`Two-factor setup failed: ${await errorMessage(confirmResponse)}`);
+            return false;
+        }
+        const { recoveryCodes } = await confirmResponse.json();
+        alert(`Two-factor authentication is on. Keep these recovery codes somewhere safe; each works once:\n\n${recoveryCodes.join('\n')}`);
+        return true;
+    };
+
     // Handle register form submission
     registerForm.addEventListener('submit', async (e) => {
         e.preventDefault();
         const username = document.getElementById('register-username').value;
         const password = document.getElementById('register-password').value;
+        const email = document.getElementById('register-email').value;
 
         const response = await fetch('/api/register', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ username, password })
+            body: JSON.stringify({ username, password, email })
         });
 
         if (response.ok) {
@@ -290,10 +387,53 @@ document.addEventListener('DOMContentLoaded', () => {
             registerForm.style.display = 'none';
             loginForm.style.display = 'block';
         } else {
-            alert('Registration failed.');
+            alert(`Registration failed: ${await errorMessage(response)}`);
         }
     });
 
+    // Handle forgotten passwords
+    document.getElementById('forgot-password').addEventListener('click', async (e) => {
+        e.preventDefault();
+        const email = prompt('Enter the email address of your account:');
+        if (!email) return;
+
+        const response = await fetch('/api/password/forgot', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ email })
+        });
+
+        if (response.ok) {
+            alert('If an account uses that address, we have sent it a link to reset your password.');
+        } else {
+            alert(`Request failed: ${await errorMessage(response)}`);
+        }
+    });
+
+    // Finish a password reset started from the emailed link
+    const resetPasswordFromLink = async () => {
+        const params = new URLSearchParams(window.location.search);
+        const token = params.get('resetToken');
+        if (!token) return;
+
+        const password = prompt('Choose a new password:');
+        if (!password) return;
+
+        const response = await fetch('/api/password/reset', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token, password })
+        });
+
+        if (response.ok) {
+            alert('Your password has been changed. Please log in.');
+            window.history.replaceState({}, '', '/');
+            loginModal.show();
+        } else {
+            alert(`Password reset failed: ${await errorMessage(response)}`);
+        }
+    };
+
     // Handle checkout
     checkoutButton.addEventListener('click', async () => {
         if (!loggedIn) {
@@ -356,7 +496,44 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     };
 
+    // Offer login with the configured OpenID Connect providers
+    const renderLoginProviders = async () => {
+        const response = await fetch('/api/oidc/providers');
+        if (!response.ok) return;
+        const providers = await response.json();
+        const container = document.getElementById('oidc-providers');
+        providers.forEach(provider => {
+            const link = document.createElement('a');
+            link.className = 'btn btn-outline-secondary me-2';
+            link.href = `/api/oidc/${encodeURIComponent(provider.name)}/login`;
+            link.textContent = `Log in with ${provider.displayName}`;
+            container.appendChild(link);
+        });
+    };
+
+    // Finish a login that came back from an OpenID Connect provider
+    const finishProviderLogin = async () => {
+        const params = new URLSearchParams(window.location.search);
+        const loginError = params.get('loginError');
+        const twoFactor = params.get('twoFactor');
+        const linked = params.get('linked');
+        if (!loginError && !twoFactor && !linked) return;
+        window.history.replaceState({}, '', '/');
+
+        if (loginError) {
+            alert(`Login failed: ${loginError}`);
+        } else if (linked) {
+            alert(`Your ${linked} login is now linked to this account.`);
+        } else if (twoFactor === 'twoFactorRequired' ? await completeTwoFactorLogin() : await setUpTwoFactor()) {
+            alert('Login successful!');
+            checkLoginStatus();
+        }
+    };
+
     // Initial setup
     checkLoginStatus();
+    renderLoginProviders();
+    finishProviderLogin();
+    resetPasswordFromLink();
     updateCartCount();
 });
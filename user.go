@@ -1,63 +0,0 @@
-
-package main
-
-import (
-	"database/sql"
-
-	"golang.org/x/crypto/bcrypt"
-)
-
-// User represents a user in the system
-type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"-"` // The password hash is not exposed in JSON
-}
-
-// HashPassword hashes a password using bcrypt
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
-}
-
-// CheckPasswordHash checks if a password matches a hash
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
-// CreateUser creates a new user in the database
-func CreateUser(db *sql.DB, username, password string) (*User, error) {
-	hashedPassword, err := HashPassword(password)
-	if err != nil {
-		return nil, err
-	}
-
-	res, err := db.Exec("INSERT INTO users (username, password) VALUES (?, ?)", username, hashedPassword)
-	if err != nil {
-		return nil, err
-	}
-
-	id, err := res.LastInsertId()
-	if err != nil {
-		return nil, err
-	}
-
-	return &User{ID: int(id), Username: username}, nil
-}
-
-// GetUserByUsername retrieves a user by their username
-func GetUserByUsername(db *sql.DB, username string) (*User, error) {
-	row := db.QueryRow("SELECT id, password FROM users WHERE username = ?", username)
-
-	var user User
-	user.Username = username
-	if err := row.Scan(&user.ID, &user.Password); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Not found
-		}
-		return nil, err
-	}
-
-	return &user, nil
-}